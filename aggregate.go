@@ -0,0 +1,114 @@
+package ql
+
+import (
+	"github.com/lincaiyong/ql/parser"
+	"strings"
+)
+
+// aggregate compiles an aggregate over the bindings of its own variables that
+// satisfy its condition. An aggregate that does not refer to any outer variable
+// is computed only once.
+func (v *Evaluator[T]) aggregate(node *parser.Node) func(*Binding[T]) *Value {
+	if node.AggregateDecls() == nil {
+		return v.groupAggregate(node)
+	}
+	n := len(v.vars)
+	inner := v.sub()
+	inner.vars = append(inner.vars, v.vars...)
+	inner.declare(node.AggregateDecls())
+	var expr, sep func(*Binding[T]) *Value
+	if node.AggregateExpr() != nil {
+		expr = inner.EvalValue(node.AggregateExpr())
+	}
+	if node.AggregateSeparator() != nil {
+		sep = v.EvalValue(node.AggregateSeparator())
+	}
+	name := node.AggregateName()
+	var conds []*parser.Node
+	if cond := node.AggregateCond(); cond != nil {
+		conds = conjuncts(cond, nil)
+	}
+	join := inner.joiner(n, conds)
+	compute := func(b *Binding[T]) *Value {
+		extended, _ := join([]*Binding[T]{b})
+		if name == "count" {
+			return NewIntValue(len(extended))
+		}
+		values := make([]*Value, 0, len(extended))
+		for _, e := range extended {
+			if value := expr(e); !value.IsNull() {
+				values = append(values, value)
+			}
+		}
+		if name == "concat" {
+			var s string
+			if sep != nil {
+				s = sep(b).StringValue()
+			}
+			return concat(values, s)
+		}
+		return v.reduce(node, values)
+	}
+	if v.correlated(node) {
+		return compute
+	}
+	var cached *Value
+	var done bool
+	return func(b *Binding[T]) *Value {
+		if !done {
+			cached = compute(b)
+			done = true
+		}
+		return cached
+	}
+}
+
+// correlated reports whether node refers to a variable in the current scope.
+func (v *Evaluator[T]) correlated(node *parser.Node) bool {
+	ret := false
+	node.Visit(func(n *parser.Node) {
+		if n.Type() == parser.NodeTypeIdent && v.lookup(n.Ident()) >= 0 {
+			ret = true
+		}
+	})
+	return ret
+}
+
+// reduce folds the values of sum, min, max and avg, which skip nulls.
+// Aggregating no values other than with sum yields null.
+func (v *Evaluator[T]) reduce(node *parser.Node, values []*Value) *Value {
+	name := node.AggregateName()
+	if name == "sum" || name == "avg" {
+		sum := NewIntValue(0)
+		for _, value := range values {
+			if !value.IsNumber() {
+				panic(newTypeError(node, "%s of %s", name, value.Type()))
+			}
+			sum = v.arith(node, "+", sum, value)
+		}
+		if name == "sum" {
+			return sum
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		return NewFloatValue(sum.NumberValue() / float64(len(values)))
+	} else if name == "min" || name == "max" {
+		var ret *Value
+		for _, value := range values {
+			if ret == nil || name == "min" && v.compare(node, "<", value, ret) || name == "max" && v.compare(node, ">", value, ret) {
+				ret = value
+			}
+		}
+		return ret
+	}
+	panic(newEvalError(node, "aggregate %s not found", name))
+}
+
+func concat(values []*Value, sep string) *Value {
+	s := make([]string, len(values))
+	for i, value := range values {
+		s[i] = value.String()
+	}
+	return NewStringValue(strings.Join(s, sep))
+}
@@ -0,0 +1,37 @@
+package ql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	db := newTestDatabase(3000)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{
+			"select Entity n where n.num > 1495 and n.num < 1501 select n.num, count(Entity c | c.par == n)",
+			[][]string{{"1496", "2"}, {"1497", "2"}, {"1498", "2"}, {"1499", "1"}, {"1500", "0"}},
+		},
+		{
+			"select Entity n where n.num < 2 select sum(Entity c, Entity d | d.par == c and c.par == n | d.num), max(Entity c | c.par == n | c.num)",
+			[][]string{{"18", "2"}, {"34", "4"}},
+		},
+		{
+			"select Entity n where count(Entity c | c.par == n and c.num % 2 == 0) == 0 and n.num > 1497 and n.num < 1502 select n.num",
+			[][]string{{"1499"}, {"1500"}, {"1501"}},
+		},
+		{
+			// an aggregate over variables of its own is computed for every row
+			"select count(Entity c | c.num < 3) from Entity n where n.num < 2",
+			[][]string{{"3"}, {"3"}},
+		},
+	}
+	for _, test := range tests {
+		if got := selectRows(t, db, test.query); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
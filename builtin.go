@@ -0,0 +1,143 @@
+package ql
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// builtin is a function callable from queries as f(s, ...) or,
+// with the first argument as receiver, as s.f(...). Unless nullable, it
+// returns null without being called if any argument is null. It panics with a
+// *TypeError or *EvalError on invalid arguments, which the caller locates.
+type builtin struct {
+	minArgs  int
+	maxArgs  int
+	params   []ValueType // argument types, the last repeating; nil if any
+	result   ValueType   // result type; empty if that of the arguments
+	fn       func(r regexps, args []*Value) *Value
+	nullable bool
+}
+
+// regexps caches the patterns compiled while evaluating a query.
+type regexps map[string]*regexp.Regexp
+
+func (r regexps) compile(pattern string) (*regexp.Regexp, error) {
+	if re, ok := r[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+	}
+	r[pattern] = re
+	return re, nil
+}
+
+// mustCompile is like compile but panics with an *EvalError.
+func (r regexps) mustCompile(pattern string) *regexp.Regexp {
+	re, err := r.compile(pattern)
+	if err != nil {
+		panic(newEvalError(nil, "%v", err))
+	}
+	return re
+}
+
+// Positions and lengths count runes, not bytes.
+var builtins = map[string]*builtin{
+	// coalesce(x, ...) is the first argument that is not null
+	"coalesce": {1, math.MaxInt, nil, "", func(_ regexps, args []*Value) *Value {
+		for _, arg := range args {
+			if !arg.IsNull() {
+				return arg
+			}
+		}
+		return nil
+	}, true},
+	"length": {1, 1, []ValueType{ValueTypeString}, ValueTypeInt, func(_ regexps, args []*Value) *Value {
+		return NewIntValue(utf8.RuneCountInString(stringArg("length", args, 0)))
+	}, false},
+	"lower": {1, 1, []ValueType{ValueTypeString}, ValueTypeString, func(_ regexps, args []*Value) *Value {
+		return NewStringValue(strings.ToLower(stringArg("lower", args, 0)))
+	}, false},
+	"upper": {1, 1, []ValueType{ValueTypeString}, ValueTypeString, func(_ regexps, args []*Value) *Value {
+		return NewStringValue(strings.ToUpper(stringArg("upper", args, 0)))
+	}, false},
+	"trim": {1, 1, []ValueType{ValueTypeString}, ValueTypeString, func(_ regexps, args []*Value) *Value {
+		return NewStringValue(strings.TrimSpace(stringArg("trim", args, 0)))
+	}, false},
+	// substr(s, start[, length])
+	"substr": {2, 3, []ValueType{ValueTypeString, ValueTypeInt}, ValueTypeString, func(_ regexps, args []*Value) *Value {
+		s := []rune(stringArg("substr", args, 0))
+		start := min(max(intArg("substr", args, 1), 0), len(s))
+		end := len(s)
+		if len(args) == 3 {
+			end = min(start+max(intArg("substr", args, 2), 0), len(s))
+		}
+		return NewStringValue(string(s[start:end]))
+	}, false},
+	"startsWith": {2, 2, []ValueType{ValueTypeString}, ValueTypeBool, func(_ regexps, args []*Value) *Value {
+		return NewBoolValue(strings.HasPrefix(stringArg("startsWith", args, 0), stringArg("startsWith", args, 1)))
+	}, false},
+	"endsWith": {2, 2, []ValueType{ValueTypeString}, ValueTypeBool, func(_ regexps, args []*Value) *Value {
+		return NewBoolValue(strings.HasSuffix(stringArg("endsWith", args, 0), stringArg("endsWith", args, 1)))
+	}, false},
+	"contains": {2, 2, []ValueType{ValueTypeString}, ValueTypeBool, func(_ regexps, args []*Value) *Value {
+		return NewBoolValue(strings.Contains(stringArg("contains", args, 0), stringArg("contains", args, 1)))
+	}, false},
+	// indexOf(s, sub) is -1 if s does not contain sub
+	"indexOf": {2, 2, []ValueType{ValueTypeString}, ValueTypeInt, func(_ regexps, args []*Value) *Value {
+		s := stringArg("indexOf", args, 0)
+		i := strings.Index(s, stringArg("indexOf", args, 1))
+		if i < 0 {
+			return NewIntValue(-1)
+		}
+		return NewIntValue(utf8.RuneCountInString(s[:i]))
+	}, false},
+	"replace": {3, 3, []ValueType{ValueTypeString}, ValueTypeString, func(_ regexps, args []*Value) *Value {
+		return NewStringValue(strings.ReplaceAll(stringArg("replace", args, 0), stringArg("replace", args, 1), stringArg("replace", args, 2)))
+	}, false},
+	// split(s, sep, i) is the i-th part of s, or no value if there are fewer parts
+	"split": {3, 3, []ValueType{ValueTypeString, ValueTypeString, ValueTypeInt}, ValueTypeString, func(_ regexps, args []*Value) *Value {
+		parts := strings.Split(stringArg("split", args, 0), stringArg("split", args, 1))
+		i := intArg("split", args, 2)
+		if i < 0 || i >= len(parts) {
+			return nil
+		}
+		return NewStringValue(parts[i])
+	}, false},
+	// regexpMatch(s, pattern) holds if pattern matches any part of s
+	"regexpMatch": {2, 2, []ValueType{ValueTypeString}, ValueTypeBool, func(r regexps, args []*Value) *Value {
+		return NewBoolValue(r.mustCompile(stringArg("regexpMatch", args, 1)).MatchString(stringArg("regexpMatch", args, 0)))
+	}, false},
+	// regexpCapture(s, pattern, group) is the text of a group of the first match,
+	// or no value if pattern does not match
+	"regexpCapture": {3, 3, []ValueType{ValueTypeString, ValueTypeString, ValueTypeInt}, ValueTypeString, func(r regexps, args []*Value) *Value {
+		re := r.mustCompile(stringArg("regexpCapture", args, 1))
+		group := intArg("regexpCapture", args, 2)
+		if group < 0 || group > re.NumSubexp() {
+			panic(newEvalError(nil, "invalid group %d of pattern %s", group, re.String()))
+		}
+		m := re.FindStringSubmatch(stringArg("regexpCapture", args, 0))
+		if m == nil {
+			return nil
+		}
+		return NewStringValue(m[group])
+	}, false},
+}
+
+func stringArg(name string, args []*Value, i int) string {
+	if args[i].Type() != ValueTypeString {
+		panic(newTypeError(nil, "argument %d of %s must be string, got %s", i, name, args[i].Type()))
+	}
+	return args[i].StringValue()
+}
+
+func intArg(name string, args []*Value, i int) int {
+	if args[i].Type() != ValueTypeInt {
+		panic(newTypeError(nil, "argument %d of %s must be int, got %s", i, name, args[i].Type()))
+	}
+	return args[i].IntValue()
+}
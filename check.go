@@ -0,0 +1,554 @@
+package ql
+
+import "github.com/lincaiyong/ql/parser"
+
+// check validates a parsed query against the tables of db before it is
+// evaluated, and returns the types inferred for the members of its classes.
+// It reports every unknown name, misused variable and operand of the wrong type
+// it finds. An empty type stands for a type that is not known statically, such
+// as that of an expression with an error, and fits anywhere.
+func check[T any](db *Database[T], module *parser.Node, params map[string]*Value) (map[*parser.Node]ValueType, ErrorList) {
+	c := &checker[T]{
+		db:         db,
+		params:     params,
+		predicates: make(map[string]*parser.Node),
+		classes:    make(map[string]*parser.Node),
+		members:    make(map[*parser.Node]ValueType),
+		pending:    make(map[*parser.Node]bool),
+	}
+	for _, decl := range module.ModuleDecls() {
+		if decl.Type() == parser.NodeTypePredicate {
+			if c.predicates[decl.PredicateName()] != nil {
+				c.errorf(decl, "predicate %s redeclared", decl.PredicateName())
+			}
+			c.predicates[decl.PredicateName()] = decl
+		} else if decl.Type() == parser.NodeTypeClass {
+			if c.hasTable(decl.ClassName()) {
+				c.errorf(decl, "table %s redeclared", decl.ClassName())
+			} else if !c.hasTable(decl.ClassBase()) {
+				c.errorf(decl, "table %s not found", decl.ClassBase())
+			} else {
+				c.classes[decl.ClassName()] = decl
+			}
+		}
+	}
+	for _, decl := range module.ModuleDecls() {
+		if decl.Type() == parser.NodeTypePredicate {
+			c.vars = nil
+			c.declare(decl.PredicateParams())
+			c.condition(decl.PredicateBody())
+		} else if c.classes[decl.ClassName()] == decl {
+			for _, member := range decl.ClassMembers() {
+				if member.MemberName() == decl.ClassName() {
+					c.vars = []checkVar{{"this", decl.ClassName()}}
+					c.condition(member.MemberBody())
+				} else {
+					c.member(decl, member)
+				}
+			}
+		}
+	}
+	c.vars = nil
+	c.query(module.ModuleQuery())
+	return c.members, c.errs
+}
+
+type checkVar struct {
+	name  string
+	table string
+}
+
+type checker[T any] struct {
+	db         *Database[T]
+	params     map[string]*Value
+	predicates map[string]*parser.Node
+	classes    map[string]*parser.Node    // classes declared in the query, by name
+	members    map[*parser.Node]ValueType // inferred types of class members
+	pending    map[*parser.Node]bool      // members whose type is being inferred, true once reported as recursive
+	vars       []checkVar
+	errs       ErrorList
+}
+
+func (c *checker[T]) errorf(node *parser.Node, format string, args ...any) {
+	c.errs = append(c.errs, newEvalError(node, format, args...))
+}
+
+func (c *checker[T]) typeErrorf(node *parser.Node, format string, args ...any) {
+	c.errs = append(c.errs, newTypeError(node, format, args...))
+}
+
+func (c *checker[T]) hasTable(name string) bool {
+	return c.classes[name] != nil || c.db.GetTable(name) != nil
+}
+
+// field returns the type of a field of table, and false if the table has no
+// such field. The fields of unknown tables, which are reported where declared,
+// are all of unknown type.
+func (c *checker[T]) field(table, name string) (ValueType, bool) {
+	if decl := c.classes[table]; decl != nil {
+		for _, member := range decl.ClassMembers() {
+			if member.MemberName() == name && name != table {
+				return c.member(decl, member), true
+			}
+		}
+		return c.field(decl.ClassBase(), name)
+	}
+	if t := c.db.GetTable(table); t != nil {
+		if t.Getter(name) == nil {
+			return "", false
+		}
+		return t.GetterType(name), true
+	}
+	return "", true
+}
+
+// member infers the type of a class member from its body. A member that
+// depends on itself is reported once and is of unknown type.
+func (c *checker[T]) member(decl, member *parser.Node) ValueType {
+	if t, ok := c.members[member]; ok {
+		return t
+	} else if reported, ok := c.pending[member]; ok {
+		if !reported {
+			c.errorf(member, "member %s depends on itself", member.MemberName())
+			c.pending[member] = true
+		}
+		return ""
+	}
+	c.pending[member] = false
+	vars := c.vars
+	c.vars = []checkVar{{"this", decl.ClassName()}}
+	t := c.value(member.MemberBody())
+	c.vars = vars
+	delete(c.pending, member)
+	c.members[member] = t
+	return t
+}
+
+// lookup returns the innermost variable named name, or nil.
+func (c *checker[T]) lookup(name string) *checkVar {
+	for i := len(c.vars) - 1; i >= 0; i-- {
+		if c.vars[i].name == name {
+			return &c.vars[i]
+		}
+	}
+	return nil
+}
+
+func (c *checker[T]) declare(decls []*parser.Node) {
+	n := len(c.vars)
+	for _, decl := range decls {
+		if !c.hasTable(decl.VarDeclTable()) {
+			c.errorf(decl, "table %s not found", decl.VarDeclTable())
+		}
+		for _, v := range c.vars[n:] {
+			if v.name == decl.VarDeclName() {
+				c.errorf(decl, "variable %s redeclared", v.name)
+			}
+		}
+		c.vars = append(c.vars, checkVar{decl.VarDeclName(), decl.VarDeclTable()})
+	}
+}
+
+// query checks a query and returns the types of its columns.
+func (c *checker[T]) query(query *parser.Node) []ValueType {
+	n := len(c.vars)
+	c.declare(query.QueryFrom())
+	if where := query.QueryWhere(); where != nil {
+		c.condition(where)
+	}
+	for _, key := range query.QueryGroupBy() {
+		c.value(key)
+	}
+	for _, item := range query.QueryOrderBy() {
+		c.value(item.OrderItemExpr())
+	}
+	// limit and offset are evaluated once, before any variable of the query is bound
+	for _, type_ := range []string{parser.NodeTypeLimit, parser.NodeTypeOffset} {
+		clause := query.QueryClause(type_)
+		if clause == nil {
+			continue
+		}
+		node := clause.ClauseExpr()
+		refers := false
+		freeVars(node, func(ident *parser.Node) {
+			for i := len(c.vars) - 1; i >= 0; i-- {
+				if c.vars[i].name == ident.Ident() {
+					if i >= n {
+						c.errorf(ident, "%s cannot refer to variable %s", type_, ident.Ident())
+						refers = true
+					}
+					return
+				}
+			}
+		})
+		if refers {
+			continue
+		}
+		vars := c.vars
+		c.vars = c.vars[:n:n]
+		if t := c.value(node); !fits(t, ValueTypeInt) {
+			c.typeErrorf(node, "expect non-negative int, got %s", t)
+		}
+		c.vars = vars
+	}
+	var columns []ValueType
+	if items := query.QuerySelect(); items != nil {
+		for _, item := range items {
+			columns = append(columns, c.value(item))
+		}
+	} else {
+		for range c.vars[n:] {
+			columns = append(columns, ValueTypeEntity)
+		}
+	}
+	return columns
+}
+
+// condition checks an expression used as a condition.
+func (c *checker[T]) condition(node *parser.Node) {
+	switch node.Type() {
+	case parser.NodeTypeParen:
+		c.condition(node.ParenTarget())
+		return
+	case parser.NodeTypeExists, parser.NodeTypeForall:
+		n := len(c.vars)
+		c.declare(node.QuantifierDecls())
+		if guard := node.QuantifierGuard(); guard != nil {
+			c.condition(guard)
+		}
+		if cond := node.QuantifierCond(); cond != nil {
+			c.condition(cond)
+		}
+		c.vars = c.vars[:n]
+		return
+	case parser.NodeTypeUnary:
+		if node.Op() == "!" || node.Op() == "not" {
+			c.condition(node.UnaryTarget())
+			return
+		}
+	case parser.NodeTypeBinary:
+		if node.Op() == "and" || node.Op() == "or" {
+			c.condition(node.BinaryLhs())
+			c.condition(node.BinaryRhs())
+			return
+		}
+	case parser.NodeTypeCall:
+		if callee := node.Callee(); callee.Type() == parser.NodeTypeIdent && c.predicates[callee.Ident()] != nil {
+			c.call(node)
+			return
+		}
+	case parser.NodeTypeIdent:
+		if c.lookup(node.Ident()) != nil {
+			c.typeErrorf(node, "variable %s used as a condition", node.Ident())
+		} else {
+			c.errorf(node, "variable %s not found", node.Ident())
+		}
+		return
+	}
+	if t := c.value(node); !fits(t, ValueTypeBool) {
+		c.typeErrorf(node, "condition of type %s", t)
+	}
+}
+
+// call checks a call of a predicate declared in the query.
+func (c *checker[T]) call(node *parser.Node) {
+	name := node.Callee().Ident()
+	decl := c.predicates[name]
+	if len(node.Args()) != len(decl.PredicateParams()) {
+		c.errorf(node, "predicate %s expects %d arguments, got %d", name, len(decl.PredicateParams()), len(node.Args()))
+	} else if node.Closure() != "" && len(decl.PredicateParams()) != 2 {
+		c.errorf(node, "closure of predicate %s must be binary", name)
+	}
+	for i, arg := range node.Args() {
+		if arg.Type() != parser.NodeTypeIdent || c.lookup(arg.Ident()) == nil {
+			c.errorf(arg, "argument %d of predicate %s must be a variable", i, name)
+		}
+	}
+}
+
+// value checks an expression used as a value and returns its type.
+func (c *checker[T]) value(node *parser.Node) ValueType {
+	switch node.Type() {
+	case parser.NodeTypeIdent:
+		if c.lookup(node.Ident()) == nil {
+			c.errorf(node, "variable %s not found", node.Ident())
+			return ""
+		}
+		return ValueTypeEntity
+	case parser.NodeTypeSelector:
+		return c.selector(node)
+	case parser.NodeTypeParen:
+		return c.value(node.ParenTarget())
+	case parser.NodeTypeAggregate:
+		return c.aggregate(node)
+	case parser.NodeTypeCall:
+		if callee := node.Callee(); callee.Type() == parser.NodeTypeIdent && c.predicates[callee.Ident()] != nil {
+			c.typeErrorf(node, "predicate %s used as a value", callee.Ident())
+			return ""
+		}
+		return c.builtin(node)
+	case parser.NodeTypeNull:
+		return ValueTypeNull
+	case parser.NodeTypeBool:
+		return ValueTypeBool
+	case parser.NodeTypeString:
+		return ValueTypeString
+	case parser.NodeTypeNumber:
+		if parser.IsFloat(node.Number()) {
+			return ValueTypeFloat
+		}
+		return ValueTypeInt
+	case parser.NodeTypeParam:
+		value, ok := c.params[node.Param()]
+		if !ok {
+			c.errorf(node, "parameter $%s not bound", node.Param())
+			return ""
+		}
+		return value.Type()
+	case parser.NodeTypeIsNull:
+		c.value(node.IsNullTarget())
+		return ValueTypeBool
+	case parser.NodeTypeIn:
+		c.in(node)
+		return ValueTypeBool
+	case parser.NodeTypeExists, parser.NodeTypeForall:
+		c.condition(node)
+		return ValueTypeBool
+	case parser.NodeTypeIf:
+		c.condition(node.IfCond())
+		return c.unify(node, c.value(node.IfThen()), c.value(node.IfElse()))
+	case parser.NodeTypeCase:
+		t := ValueTypeNull
+		for _, when := range node.CaseWhens() {
+			c.condition(when.WhenCond())
+			t = c.unify(when.WhenResult(), t, c.value(when.WhenResult()))
+		}
+		if node.CaseElse() != nil {
+			t = c.unify(node.CaseElse(), t, c.value(node.CaseElse()))
+		}
+		return t
+	case parser.NodeTypeUnary:
+		if node.Op() == "!" || node.Op() == "not" {
+			c.condition(node)
+			return ValueTypeBool
+		} else if node.Op() != "-" {
+			c.errorf(node, "invalid operator %s", node.Op())
+			return ""
+		}
+		t := c.value(node.UnaryTarget())
+		if !fits(t, ValueTypeFloat) {
+			c.typeErrorf(node, "cannot apply - to %s", t)
+			return ""
+		}
+		return t
+	case parser.NodeTypeBinary:
+		if node.Op() == "and" || node.Op() == "or" {
+			c.condition(node)
+			return ValueTypeBool
+		}
+		return c.binary(node)
+	}
+	c.typeErrorf(node, "%s used as a value", node.Type())
+	return ""
+}
+
+func (c *checker[T]) selector(node *parser.Node) ValueType {
+	key := node.SelectorKey()
+	target := node.SelectorTarget()
+	if target == nil {
+		c.errorf(node, "selector .%s without target", key)
+		return ""
+	} else if target.Type() == parser.NodeTypeIdent {
+		v := c.lookup(target.Ident())
+		if v == nil {
+			c.errorf(target, "variable %s not found", target.Ident())
+			return ""
+		}
+		t, ok := c.field(v.table, key)
+		if !ok {
+			c.errorf(node, "field %s of %s not found", key, v.name)
+		}
+		return t
+	}
+	if t := c.value(target); !fits(t, ValueTypeEntity) {
+		c.typeErrorf(target, "selector .%s on %s", key, t)
+		return ""
+	}
+	t, ok := c.field(c.db.GetBaseTable().name, key)
+	if !ok {
+		c.errorf(node, "field %s not found", key)
+	}
+	return t
+}
+
+func (c *checker[T]) binary(node *parser.Node) ValueType {
+	op := node.Op()
+	lhs, rhs := c.value(node.BinaryLhs()), c.value(node.BinaryRhs())
+	if isArithOp(op) {
+		if op == "+" && fits(lhs, ValueTypeString) && fits(rhs, ValueTypeString) && (lhs == ValueTypeString || rhs == ValueTypeString) {
+			return ValueTypeString
+		} else if !fits(lhs, ValueTypeFloat) || !fits(rhs, ValueTypeFloat) {
+			c.typeErrorf(node, "cannot apply %s to %s and %s", op, lhs, rhs)
+			return ""
+		}
+		return c.unify(node, lhs, rhs)
+	}
+	if op == "=~" || op == "matches" {
+		if !fits(lhs, ValueTypeString) || !fits(rhs, ValueTypeString) {
+			c.typeErrorf(node, "cannot apply %s to %s and %s", op, lhs, rhs)
+		}
+	} else if isCompareOp(op) && !comparableTypes(op, lhs, rhs) {
+		c.typeErrorf(node, "cannot apply %s to %s and %s", op, lhs, rhs)
+	}
+	return ValueTypeBool
+}
+
+func (c *checker[T]) in(node *parser.Node) {
+	lhs := c.value(node.InTarget())
+	set := node.InSet()
+	if set.Type() == parser.NodeTypeQuery {
+		correlated := false
+		freeVars(set, func(ident *parser.Node) {
+			if c.lookup(ident.Ident()) != nil {
+				c.errorf(ident, "correlated subqueries are not supported")
+				correlated = true
+			}
+		})
+		if correlated {
+			return
+		}
+		vars := c.vars
+		c.vars = nil
+		columns := c.query(set)
+		c.vars = vars
+		if len(columns) != 1 {
+			c.errorf(set, "subquery selects %d columns", len(columns))
+		} else if !comparableTypes("==", lhs, columns[0]) {
+			c.typeErrorf(node, "cannot apply in to %s and %s", lhs, columns[0])
+		}
+		return
+	}
+	for _, item := range set.ListItems() {
+		if t := c.value(item); !comparableTypes("==", lhs, t) {
+			c.typeErrorf(item, "cannot apply in to %s and %s", lhs, t)
+		}
+	}
+}
+
+func (c *checker[T]) aggregate(node *parser.Node) ValueType {
+	name := node.AggregateName()
+	if sep := node.AggregateSeparator(); sep != nil {
+		if t := c.value(sep); !fits(t, ValueTypeString) {
+			c.typeErrorf(sep, "separator of type %s", t)
+		}
+	}
+	n := len(c.vars)
+	c.declare(node.AggregateDecls())
+	defer func() {
+		c.vars = c.vars[:n]
+	}()
+	if cond := node.AggregateCond(); cond != nil {
+		c.condition(cond)
+	}
+	var t ValueType
+	if expr := node.AggregateExpr(); expr != nil {
+		t = c.value(expr)
+	}
+	switch name {
+	case "count":
+		return ValueTypeInt
+	case "concat":
+		return ValueTypeString
+	case "sum", "avg":
+		if !fits(t, ValueTypeFloat) {
+			c.typeErrorf(node, "%s of %s", name, t)
+			return ""
+		} else if name == "avg" {
+			return ValueTypeFloat
+		}
+	}
+	return t
+}
+
+func (c *checker[T]) builtin(node *parser.Node) ValueType {
+	var name string
+	args := node.Args()
+	callee := node.Callee()
+	if callee.Type() == parser.NodeTypeIdent {
+		name = callee.Ident()
+	} else if callee.Type() == parser.NodeTypeSelector && callee.SelectorTarget() != nil {
+		name = callee.SelectorKey()
+		args = append([]*parser.Node{callee.SelectorTarget()}, args...)
+	} else {
+		c.errorf(callee, "%s is not a function", callee.Type())
+		return ""
+	}
+	types := make([]ValueType, len(args))
+	for i, arg := range args {
+		types[i] = c.value(arg)
+	}
+	f := builtins[name]
+	if f == nil {
+		c.errorf(callee, "function %s not found", name)
+		return ""
+	} else if len(args) < f.minArgs || len(args) > f.maxArgs {
+		c.errorf(node, "invalid number of arguments to %s: %d", name, len(args))
+		return f.result
+	}
+	if f.params == nil {
+		t := ValueTypeNull
+		for i, arg := range args {
+			t = c.unify(arg, t, types[i])
+		}
+		return t
+	}
+	for i, arg := range args {
+		want := f.params[min(i, len(f.params)-1)]
+		if t := types[i]; t != "" && t != ValueTypeNull && t != want {
+			c.typeErrorf(arg, "argument %d of %s must be %s, got %s", i, name, want, t)
+		}
+	}
+	return f.result
+}
+
+// unify returns the type of an expression whose value is of type a or b, as
+// the branches of an if.
+func (c *checker[T]) unify(node *parser.Node, a, b ValueType) ValueType {
+	switch {
+	case a == "" || b == "":
+		return ""
+	case a == ValueTypeNull || a == b:
+		return b
+	case b == ValueTypeNull:
+		return a
+	case isNumberType(a) && isNumberType(b):
+		return ValueTypeFloat
+	}
+	c.typeErrorf(node, "mixing %s and %s", a, b)
+	return ""
+}
+
+func isNumberType(t ValueType) bool {
+	return t == ValueTypeInt || t == ValueTypeFloat
+}
+
+// fits reports whether a value of type t may be used where want is expected,
+// where float also stands for any number.
+func fits(t, want ValueType) bool {
+	if t == "" || t == ValueTypeNull || t == want {
+		return true
+	}
+	return want == ValueTypeFloat && t == ValueTypeInt
+}
+
+// comparableTypes reports whether values of types a and b may be compared with op.
+func comparableTypes(op string, a, b ValueType) bool {
+	if a == "" || b == "" || a == ValueTypeNull || b == ValueTypeNull {
+		return true
+	} else if isNumberType(a) && isNumberType(b) {
+		return true
+	} else if a != b {
+		return false
+	}
+	return a == ValueTypeString || op == "==" || op == "!="
+}
@@ -0,0 +1,59 @@
+package ql
+
+import (
+	"github.com/lincaiyong/ql/parser"
+	"maps"
+)
+
+// defineClass materializes a class declared in the query as a table holding the
+// records of its base table that satisfy its characteristic predicate.
+func (v *Evaluator[T]) defineClass(decl *parser.Node) {
+	base := v.table(decl.ClassBase())
+	if base == nil {
+		panic(newEvalError(decl, "table %s not found", decl.ClassBase()))
+	}
+	table := NewTable[T](decl.ClassName(), v.db, nil, maps.Clone(base.getters), maps.Clone(base.types))
+	var charPred *parser.Node
+	for _, member := range decl.ClassMembers() {
+		if member.MemberName() == decl.ClassName() {
+			charPred = member.MemberBody()
+			continue
+		}
+		table.Define(member.MemberName(), v.types[member], v.member(table, member))
+	}
+	bindings := make([]*Binding[T], 0, len(base.records))
+	for _, record := range base.records {
+		bindings = append(bindings, &Binding[T]{records: []*Record[T]{record}})
+	}
+	if charPred != nil {
+		sub := v.sub()
+		sub.vars = []*Variable[T]{NewVariable[T]("this", table)}
+		bindings = sub.EvalSet(charPred, bindings)
+	}
+	for _, b := range bindings {
+		table.AddRecord(NewRecord[T](table, b.records[0].id, nil))
+	}
+	v.tables[decl.ClassName()] = table
+}
+
+// member returns a getter evaluating the body of member with this bound to the
+// entity. The body is compiled on first use so that members may refer to each
+// other, and a member whose value for an entity depends on itself is an error.
+func (v *Evaluator[T]) member(table *Table[T], member *parser.Node) func(*T) *Value {
+	var value func(*Binding[T]) *Value
+	active := make(map[*T]bool)
+	return func(e *T) *Value {
+		if active[e] {
+			panic(newEvalError(member, "member %s depends on itself", member.MemberName()))
+		}
+		active[e] = true
+		defer delete(active, e)
+		if value == nil {
+			sub := v.sub()
+			sub.vars = []*Variable[T]{NewVariable[T]("this", table)}
+			value = sub.EvalValue(member.MemberBody())
+		}
+		record := v.db.GetBaseTable().recordMap[v.db.ids[e]]
+		return value(&Binding[T]{records: []*Record[T]{record}})
+	}
+}
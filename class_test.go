@@ -0,0 +1,32 @@
+package ql
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClass(t *testing.T) {
+	db := newTestDatabase(10)
+	q := "class Big extends Entity { Big() { this.num > 6 } twice() { this.num * 2 } half() { this.twice / 4 } } select Big b select b.num, b.twice, b.half"
+	want := [][]string{{"7", "14", "3"}, {"8", "16", "4"}, {"9", "18", "4"}}
+	if got := selectRows(t, db, q); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %v, want %v", q, got, want)
+	}
+}
+
+func TestClassRecursiveMember(t *testing.T) {
+	db := newTestDatabase(10)
+	for _, q := range []string{
+		"class C extends Entity { a() { this.a + this.a } } select C c select c.a",
+		"class C extends Entity { a() { this.b } b() { this.a } } select C c select c.a",
+	} {
+		_, err := db.Select(q)
+		var list ErrorList
+		var e *EvalError
+		if !errors.As(err, &list) || len(list) != 1 || !errors.As(err, &e) || !strings.Contains(e.Error(), "depends on itself") {
+			t.Errorf("%s: got error %v, want one member depending on itself", q, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Command ql works with query files.
+//
+// Usage:
+//
+//	ql fmt [-l] [-w] [path ...]
+//
+// fmt prints the files in canonical form, as produced by parser.FormatSource.
+// Directories are walked for .ql files, and without paths it formats standard
+// input. With -l it lists the files whose formatting differs, and with -w it
+// writes the result back to them, instead of printing it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/lincaiyong/ql/parser"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "fmt" {
+		fmt.Fprintln(os.Stderr, "usage: ql fmt [-l] [-w] [path ...]")
+		os.Exit(2)
+	}
+	os.Exit(formatCommand(os.Args[2:]))
+}
+
+// formatCommand runs ql fmt with args and returns the exit code.
+func formatCommand(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	list := flags.Bool("l", false, "list files whose formatting differs")
+	write := flags.Bool("w", false, "write result to the files instead of standard output")
+	_ = flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "ql fmt: cannot use -w with standard input")
+			return 2
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err == nil {
+			err = formatFile("<standard input>", src, *list, false)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	code := 0
+	for _, path := range flags.Args() {
+		err := filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// files named on the command line are formatted whatever their extension
+			if d.IsDir() || name != path && filepath.Ext(name) != ".ql" {
+				return nil
+			}
+			src, err := os.ReadFile(name)
+			if err == nil {
+				err = formatFile(name, src, *list, *write)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = 1
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// formatFile formats the content src of the file name, then lists, writes back
+// or prints it.
+func formatFile(name string, src []byte, list, write bool) error {
+	out, err := parser.FormatSource(string(src))
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	changed := string(src) != out
+	if list && changed {
+		fmt.Println(name)
+	}
+	if write && changed {
+		return os.WriteFile(name, []byte(out), 0644)
+	}
+	if !list && !write {
+		fmt.Print(out)
+	}
+	return nil
+}
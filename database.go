@@ -2,20 +2,21 @@ package ql
 
 import (
 	"fmt"
-	"regexp"
-	"strings"
+	"github.com/lincaiyong/ql/parser"
 )
 
 func NewDatabase[T any](entities []*T) *Database[T] {
 	db := &Database[T]{
 		entities: entities,
+		ids:      make(map[*T]int, len(entities)),
 		strs:     make([]string, 0),
 		strMap:   make(map[string]int),
 		tables:   make([]*Table[T], 0),
 		tableMap: make(map[string]*Table[T]),
 	}
-	table := NewTable[T]("Entity", db, nil, nil)
-	for i := range entities {
+	table := NewTable[T]("Entity", db, nil, nil, nil)
+	for i, e := range entities {
+		db.ids[e] = i
 		table.AddRecord(NewRecord[T](table, i, nil))
 	}
 	db.tableMap["Entity"] = table
@@ -25,6 +26,7 @@ func NewDatabase[T any](entities []*T) *Database[T] {
 
 type Database[T any] struct {
 	entities []*T
+	ids      map[*T]int
 	strs     []string
 	strMap   map[string]int
 	tables   []*Table[T]
@@ -59,7 +61,7 @@ func (db *Database[T]) AddTable(baseTableName, tableName string, fields []string
 	if !ok {
 		return nil, fmt.Errorf("table %s not found", baseTableName)
 	}
-	table := NewTable[T](tableName, db, fields, baseTable.getters)
+	table := NewTable[T](tableName, db, fields, baseTable.getters, baseTable.types)
 	db.tableMap[tableName] = table
 	db.tables = append(db.tables, table)
 	for _, record := range baseTable.records {
@@ -72,28 +74,108 @@ func (db *Database[T]) AddTable(baseTableName, tableName string, fields []string
 }
 
 func (db *Database[T]) Query(q string) ([]*T, error) {
-	ret := regexp.MustCompile(`^select (.+?)(?:where (.+))?$`).FindStringSubmatch(q)
-	if len(ret) != 3 {
-		return nil, fmt.Errorf("invalid query statement: %s", q)
+	return db.QueryWith(q, nil)
+}
+
+// QueryWith is like Query, with the bind parameters of q, written $name, taking
+// their values from params; a nil value is null.
+func (db *Database[T]) QueryWith(q string, params map[string]*Value) ([]*T, error) {
+	return entities(db.query(q, params))
+}
+
+// QueryNode is like Query, for a query already parsed, such as one decoded from
+// JSON. Errors locate problems by the spans of the tokens of node.
+func (db *Database[T]) QueryNode(node *parser.Node) ([]*T, error) {
+	return db.QueryNodeWith(node, nil)
+}
+
+func (db *Database[T]) QueryNodeWith(node *parser.Node, params map[string]*Value) ([]*T, error) {
+	return entities(db.queryNode(node, params))
+}
+
+// entities returns the entities bound to the only variable of a query result.
+func entities[T any](ret *result[T], err error) ([]*T, error) {
+	if err != nil {
+		return nil, err
 	}
-	select_ := ret[1]
-	where := ret[2]
-	s := strings.Fields(select_)
-	if len(s) != 2 {
-		return nil, fmt.Errorf("invalid query statement: %s", q)
+	if len(ret.vars) != 1 {
+		return nil, fmt.Errorf("query binds %d variables, use QueryTuples instead", len(ret.vars))
 	}
-	table := db.GetTable(s[0])
-	if table == nil {
-		return nil, fmt.Errorf("table %s not found", s[0])
+	result := make([]*T, 0, len(ret.bindings))
+	for _, b := range ret.bindings {
+		result = append(result, b.records[0].Entity())
 	}
-	varName := s[1]
-	records, err := eval[T](table, varName, where)
+	return result, nil
+}
+
+func (db *Database[T]) QueryTuples(q string) ([][]*T, error) {
+	return db.QueryTuplesWith(q, nil)
+}
+
+func (db *Database[T]) QueryTuplesWith(q string, params map[string]*Value) ([][]*T, error) {
+	return tuples(db.query(q, params))
+}
+
+func (db *Database[T]) QueryTuplesNode(node *parser.Node) ([][]*T, error) {
+	return db.QueryTuplesNodeWith(node, nil)
+}
+
+func (db *Database[T]) QueryTuplesNodeWith(node *parser.Node, params map[string]*Value) ([][]*T, error) {
+	return tuples(db.queryNode(node, params))
+}
+
+// tuples returns the entities bound to the variables of a query result.
+func tuples[T any](ret *result[T], err error) ([][]*T, error) {
 	if err != nil {
-		return nil, fmt.Errorf("fail to eval: %w", err)
+		return nil, err
 	}
-	result := make([]*T, 0)
-	for _, r := range records {
-		result = append(result, db.entities[r.id])
+	result := make([][]*T, 0, len(ret.bindings))
+	for _, b := range ret.bindings {
+		tuple := make([]*T, len(b.records))
+		for i, r := range b.records {
+			tuple[i] = r.Entity()
+		}
+		result = append(result, tuple)
 	}
 	return result, nil
 }
+
+func (db *Database[T]) Select(q string) (*ResultSet, error) {
+	return db.SelectWith(q, nil)
+}
+
+func (db *Database[T]) SelectWith(q string, params map[string]*Value) (*ResultSet, error) {
+	return rows(db.query(q, params))
+}
+
+func (db *Database[T]) SelectNode(node *parser.Node) (*ResultSet, error) {
+	return db.SelectNodeWith(node, nil)
+}
+
+func (db *Database[T]) SelectNodeWith(node *parser.Node, params map[string]*Value) (*ResultSet, error) {
+	return rows(db.queryNode(node, params))
+}
+
+func rows[T any](ret *result[T], err error) (*ResultSet, error) {
+	if err != nil {
+		return nil, err
+	}
+	return ret.rows, nil
+}
+
+func (db *Database[T]) query(q string, params map[string]*Value) (*result[T], error) {
+	node, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	return eval[T](db, q, node, params)
+}
+
+// queryNode evaluates node, a module as returned by parser.ParseQuery, without
+// the text it was parsed from.
+func (db *Database[T]) queryNode(node *parser.Node, params map[string]*Value) (*result[T], error) {
+	if node == nil || node.Type() != parser.NodeTypeModule {
+		return nil, &EvalError{queryError{Msg: "query node is not a module"}}
+	}
+	return eval[T](db, "", node, params)
+}
@@ -0,0 +1,50 @@
+package ql
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/lincaiyong/ql/parser"
+	"reflect"
+	"testing"
+)
+
+func TestSelectNode(t *testing.T) {
+	db := newTestDatabase(10)
+	q := "select Entity n where n.num > $min select n.num"
+	tokens, err := parser.Tokenize(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := parser.ParseQuery(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded parser.Node
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := db.SelectNodeWith(&decoded, map[string]*Value{"min": NewIntValue(7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rs.Rows(), [][]*Value{{NewIntValue(8)}, {NewIntValue(9)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	entities, err := db.QueryNodeWith(&decoded, map[string]*Value{"min": NewIntValue(8)})
+	if err != nil || len(entities) != 1 || entities[0].num != 9 {
+		t.Errorf("got %v, %v, want entity 9", entities, err)
+	}
+
+	_, err = db.SelectNode(&decoded)
+	var e *EvalError
+	if !errors.As(err, &e) || e.Span == nil || e.Span.Column != 31 {
+		t.Errorf("got error %v, want an unbound parameter at column 31", err)
+	}
+	if _, err := db.SelectNode(node.ModuleQuery()); err == nil {
+		t.Errorf("got no error for a query node, want one")
+	}
+}
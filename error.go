@@ -0,0 +1,163 @@
+package ql
+
+import (
+	"fmt"
+	"github.com/lincaiyong/ql/parser"
+	"strings"
+)
+
+// ParseError reports a query that fails to tokenize or parse.
+type ParseError struct {
+	queryError
+}
+
+func (e *ParseError) Error() string {
+	return e.format("parse error")
+}
+
+// TypeError reports a value whose type does not fit the operator, function or
+// clause it is used with.
+type TypeError struct {
+	queryError
+}
+
+func (e *TypeError) Error() string {
+	return e.format("type error")
+}
+
+// EvalError reports any other failure to evaluate a query, such as an unknown
+// name or a division by zero.
+type EvalError struct {
+	queryError
+}
+
+func (e *EvalError) Error() string {
+	return e.format("eval error")
+}
+
+// ErrorList reports every problem found in a query before evaluating it, each
+// a *TypeError or an *EvalError.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	s := make([]string, len(l))
+	for i, err := range l {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+func (l ErrorList) Unwrap() []error {
+	return l
+}
+
+// queryError is an error at a span of a query.
+type queryError struct {
+	Query string
+	Span  *parser.Token // the offending text, or nil if unknown
+	Msg   string
+}
+
+func (e *queryError) format(kind string) string {
+	if e.Span == nil {
+		return fmt.Sprintf("%s: %s", kind, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s at line %d, column %d", kind, e.Msg, e.Span.Line, e.Span.Column)
+}
+
+// Render returns the message followed by the lines of the query covered by the
+// span, each underlined with carets.
+func (e *queryError) Render() string {
+	if e.Span == nil {
+		return e.Msg
+	}
+	var sb strings.Builder
+	sb.WriteString(e.Msg)
+	start, end := e.Span.Start, max(e.Span.End, e.Span.Start+1)
+	offset := 0
+	for i, line := range strings.Split(e.Query, "\n") {
+		if start <= offset+len(line) && end > offset {
+			sb.WriteString(fmt.Sprintf("\n%4d | %s\n     | ", i+1, strings.TrimRight(line, "\r")))
+			// the extra space stands for the end of the line
+			for j, r := range line + " " {
+				if offset+j >= end {
+					break
+				} else if offset+j >= start {
+					sb.WriteByte('^')
+				} else if r == '\t' {
+					sb.WriteByte('\t')
+				} else {
+					sb.WriteByte(' ')
+				}
+			}
+		}
+		offset += len(line) + 1
+	}
+	return sb.String()
+}
+
+func (e *queryError) setQuery(query string) {
+	e.Query = query
+	if e.Span != nil && e.Span.End <= len(query) {
+		e.Span.Text = query[e.Span.Start:e.Span.End]
+	}
+}
+
+// span returns a token covering the text of node.
+func span(node *parser.Node) *parser.Token {
+	if node == nil {
+		return nil
+	}
+	first, last := node.Span()
+	if first == nil {
+		return nil
+	}
+	tok := parser.NewToken(first.Type, "", first.Start, last.End)
+	tok.Line, tok.Column = first.Line, first.Column
+	return tok
+}
+
+func newTypeError(node *parser.Node, format string, args ...any) *TypeError {
+	return &TypeError{queryError{Span: span(node), Msg: fmt.Sprintf(format, args...)}}
+}
+
+func newEvalError(node *parser.Node, format string, args ...any) *EvalError {
+	return &EvalError{queryError{Span: span(node), Msg: fmt.Sprintf(format, args...)}}
+}
+
+// located is implemented by the errors raised while evaluating a query.
+type located interface {
+	error
+	setQuery(query string)
+	setSpan(node *parser.Node)
+}
+
+// setSpan gives the error the span of node unless it already has one.
+func (e *queryError) setSpan(node *parser.Node) {
+	if e.Span == nil {
+		e.Span = span(node)
+	}
+}
+
+// locate gives an error panicking without a position, as raised by a built-in
+// function, the span of node. It must be deferred.
+func locate(node *parser.Node) {
+	if r := recover(); r != nil {
+		if e, ok := r.(located); ok {
+			e.setSpan(node)
+		}
+		panic(r)
+	}
+}
+
+// recovered turns an error raised during evaluation, recovered from a panic,
+// into an error of query. Any other value is a bug, in the evaluator or in a
+// getter, and panics again.
+func recovered(r any, query string) error {
+	e, ok := r.(located)
+	if !ok {
+		panic(r)
+	}
+	e.setQuery(query)
+	return e
+}
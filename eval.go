@@ -2,69 +2,329 @@ package ql
 
 import (
 	"fmt"
-	"github.com/lincaiyong/log"
 	"github.com/lincaiyong/ql/parser"
-	"strconv"
-	"strings"
+	"math"
 )
 
-func eval[T any](table *Table[T], varName, where string) (result []*Record[T], err error) {
+type result[T any] struct {
+	vars     []*Variable[T]
+	bindings []*Binding[T]
+	rows     *ResultSet
+}
+
+func eval[T any](db *Database[T], q string, module *parser.Node, params map[string]*Value) (ret *result[T], err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = r.(error)
+			ret, err = nil, recovered(r, q)
 		}
 	}()
-	if where == "" {
-		return table.Records(), nil
-	}
-	node, err := parse(where)
-	if err != nil {
-		return nil, err
+	types, errs := check(db, module, params)
+	if errs != nil {
+		for _, e := range errs {
+			e.(located).setQuery(q)
+		}
+		return nil, errs
 	}
 	v := Evaluator[T]{
-		varName: varName,
-		table:   table,
+		db:         db,
+		predicates: make(map[string]*parser.Node),
+		relations:  make(map[string]map[string][]*Record[T]),
+		pending:    make(map[string]bool),
+		tables:     make(map[string]*Table[T]),
+		regexps:    make(regexps),
+		params:     params,
+		types:      types,
 	}
-	result = v.EvalSet(node, table.Records())
-	return result, nil
+	for _, decl := range module.ModuleDecls() {
+		if decl.Type() == parser.NodeTypePredicate {
+			v.predicates[decl.PredicateName()] = decl
+		}
+	}
+	v.recursive = recursivePredicates(v.predicates)
+	for _, decl := range module.ModuleDecls() {
+		if decl.Type() == parser.NodeTypeClass {
+			v.defineClass(decl)
+		}
+	}
+	return v.query(module.ModuleQuery()), nil
 }
 
-func parse(expr string) (*parser.Node, error) {
-	tokens, err := parser.Tokenize(expr)
-	if err != nil {
-		return nil, fmt.Errorf("fail to tokenize: %w", err)
+// query evaluates a query in an evaluator with no variables in scope.
+func (v *Evaluator[T]) query(query *parser.Node) *result[T] {
+	v.declare(query.QueryFrom())
+	ret := &result[T]{vars: v.vars}
+	var conds []*parser.Node
+	if where := query.QueryWhere(); where != nil {
+		conds = conjuncts(where, nil)
+	}
+	ret.bindings, _ = v.join([]*Binding[T]{{}}, 0, conds)
+	groups := v.paginate(query, v.group(query, ret.bindings))
+	ret.bindings = make([]*Binding[T], len(groups))
+	for i, g := range groups {
+		ret.bindings[i] = v.first(g)
 	}
-	node, err := parser.Parse(tokens)
-	if err != nil {
-		return nil, fmt.Errorf("fail to parse: %w", err)
+	if items := query.QuerySelect(); items != nil {
+		ret.rows = v.project(items, groups)
+	} else {
+		ret.rows = v.projectVars(ret.bindings)
+	}
+	return ret
+}
+
+func parseQuery(query string) (*parser.Node, error) {
+	tokens, err := parser.Tokenize(query)
+	if err == nil {
+		var node *parser.Node
+		if node, err = parser.ParseQuery(tokens); err == nil {
+			return node, nil
+		}
+	}
+	e := &ParseError{queryError{Query: query, Msg: err.Error()}}
+	if pe, ok := err.(*parser.Error); ok {
+		e.Span, e.Msg = pe.Token, pe.Msg
+	}
+	return nil, e
+}
+
+func NewVariable[T any](name string, table *Table[T]) *Variable[T] {
+	return &Variable[T]{
+		name:  name,
+		table: table,
 	}
-	return node, nil
+}
+
+type Variable[T any] struct {
+	name  string
+	table *Table[T]
+}
+
+func (v *Variable[T]) Name() string {
+	return v.name
+}
+
+func (v *Variable[T]) Table() *Table[T] {
+	return v.table
+}
+
+// Binding assigns one record to every variable in scope, in declaration order.
+type Binding[T any] struct {
+	records []*Record[T]
+}
+
+func (b *Binding[T]) Records() []*Record[T] {
+	return b.records
 }
 
 type Evaluator[T any] struct {
-	table   *Table[T]
-	varName string
+	db         *Database[T]
+	vars       []*Variable[T]
+	predicates map[string]*parser.Node
+	recursive  map[string]bool
+	relations  map[string]map[string][]*Record[T] // materialized predicates, or their approximation while pending
+	pending    map[string]bool                    // predicates whose fixpoint is being computed
+	tables     map[string]*Table[T]               // classes declared in the query
+	rows       []*Binding[T]                      // the group of bindings being projected
+	regexps    regexps
+	params     map[string]*Value          // values of bind parameters
+	types      map[*parser.Node]ValueType // types of class members
+}
+
+// sub returns an evaluator sharing the declarations of v but with no variables in scope.
+func (v *Evaluator[T]) sub() *Evaluator[T] {
+	return &Evaluator[T]{
+		db:         v.db,
+		predicates: v.predicates,
+		recursive:  v.recursive,
+		relations:  v.relations,
+		pending:    v.pending,
+		tables:     v.tables,
+		regexps:    v.regexps,
+		params:     v.params,
+	}
+}
+
+func (v *Evaluator[T]) table(name string) *Table[T] {
+	if table, ok := v.tables[name]; ok {
+		return table
+	}
+	return v.db.GetTable(name)
+}
+
+// lookup returns the slot of the innermost variable named name, or -1.
+func (v *Evaluator[T]) lookup(name string) int {
+	for i := len(v.vars) - 1; i >= 0; i-- {
+		if v.vars[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// product returns the cartesian product of the records of all variables in scope.
+func (v *Evaluator[T]) product() []*Binding[T] {
+	result, _ := v.extend([]*Binding[T]{{}}, 0)
+	return result
+}
+
+func (v *Evaluator[T]) project(items []*parser.Node, groups [][]*Binding[T]) *ResultSet {
+	columns := make([]string, len(items))
+	getters := make([]func(*Binding[T]) *Value, len(items))
+	for i, item := range items {
+		columns[i] = columnName(item, i)
+		getters[i] = v.EvalValue(item)
+	}
+	rs := NewResultSet(columns)
+	for _, g := range groups {
+		v.rows = g
+		row := make([]*Value, len(getters))
+		for i, getter := range getters {
+			if row[i] = getter(v.first(g)); row[i] == nil {
+				row[i] = NewNullValue()
+			}
+		}
+		rs.AddRow(row)
+	}
+	v.rows = nil
+	return rs
+}
+
+// projectVars selects the entities bound to the variables of a query without select items.
+func (v *Evaluator[T]) projectVars(bindings []*Binding[T]) *ResultSet {
+	columns := make([]string, len(v.vars))
+	for i, variable := range v.vars {
+		columns[i] = variable.name
+	}
+	rs := NewResultSet(columns)
+	for _, b := range bindings {
+		row := make([]*Value, len(b.records))
+		for i, r := range b.records {
+			row[i] = NewEntityValue(r.Entity())
+		}
+		rs.AddRow(row)
+	}
+	return rs
+}
+
+// columnName names a select item after its field, variable or aggregate, or by position otherwise.
+func columnName(item *parser.Node, i int) string {
+	switch item.Type() {
+	case parser.NodeTypeSelector:
+		return item.SelectorKey()
+	case parser.NodeTypeIdent:
+		return item.Ident()
+	case parser.NodeTypeAggregate:
+		return item.AggregateName()
+	}
+	return fmt.Sprintf("col%d", i)
+}
+
+// extend binds the variables from slot n onwards for every binding in all; the
+// returned map gives the binding each extended binding was derived from.
+func (v *Evaluator[T]) extend(all []*Binding[T], n int) ([]*Binding[T], map[*Binding[T]]*Binding[T]) {
+	result := all
+	parents := make(map[*Binding[T]]*Binding[T], len(all))
+	for _, b := range all {
+		parents[b] = b
+	}
+	for _, variable := range v.vars[n:] {
+		records := variable.table.Records()
+		next := make([]*Binding[T], 0, len(result)*len(records))
+		for _, b := range result {
+			for _, record := range records {
+				rs := make([]*Record[T], len(b.records), len(b.records)+1)
+				copy(rs, b.records)
+				e := &Binding[T]{records: append(rs, record)}
+				parents[e] = parents[b]
+				next = append(next, e)
+			}
+		}
+		result = next
+	}
+	return result, parents
+}
+
+func (v *Evaluator[T]) declare(decls []*parser.Node) {
+	for _, decl := range decls {
+		table := v.table(decl.VarDeclTable())
+		if table == nil {
+			panic(newEvalError(decl, "table %s not found", decl.VarDeclTable()))
+		}
+		v.vars = append(v.vars, NewVariable[T](decl.VarDeclName(), table))
+	}
+}
+
+func (v *Evaluator[T]) quantify(node *parser.Node, all []*Binding[T]) []*Binding[T] {
+	n := len(v.vars)
+	v.declare(node.QuantifierDecls())
+	defer func() {
+		v.vars = v.vars[:n]
+	}()
+	// the conditions every extension must satisfy are applied while joining
+	var conds []*parser.Node
+	if guard := node.QuantifierGuard(); guard != nil {
+		conds = conjuncts(guard, nil)
+	} else if cond := node.QuantifierCond(); cond != nil && node.Type() == parser.NodeTypeExists {
+		conds = conjuncts(cond, nil)
+	}
+	extended, parents := v.join(all, n, conds)
+	m := make(map[*Binding[T]]struct{})
+	if node.Type() == parser.NodeTypeExists {
+		for _, e := range extended {
+			m[parents[e]] = struct{}{}
+		}
+		return filter(all, m, true)
+	}
+	// forall fails for every binding that has a counterexample
+	for _, e := range v.minus(extended, v.EvalSet(node.QuantifierCond(), extended)) {
+		m[parents[e]] = struct{}{}
+	}
+	return filter(all, m, false)
 }
 
-func (v *Evaluator[T]) EvalSet(node *parser.Node, all []*Record[T]) []*Record[T] {
+// minus returns the bindings of all that are not in s.
+func (v *Evaluator[T]) minus(all, s []*Binding[T]) []*Binding[T] {
+	m := make(map[*Binding[T]]struct{}, len(s))
+	for _, b := range s {
+		m[b] = struct{}{}
+	}
+	return filter(all, m, false)
+}
+
+// filter keeps the bindings of all whose membership in m equals in, preserving order.
+func filter[T any](all []*Binding[T], m map[*Binding[T]]struct{}, in bool) []*Binding[T] {
+	result := make([]*Binding[T], 0, len(all))
+	for _, b := range all {
+		if _, ok := m[b]; ok == in {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+func (v *Evaluator[T]) EvalSet(node *parser.Node, all []*Binding[T]) []*Binding[T] {
 	switch node.Type() {
 	case parser.NodeTypeIdent:
 		name := node.Ident()
-		if name == v.varName {
-			log.FatalLog("invalid identifier %s", name)
-			return nil
+		if v.lookup(name) >= 0 {
+			panic(newTypeError(node, "variable %s used as a condition", name))
 		}
 		return all
+	case parser.NodeTypeParen:
+		return v.EvalSet(node.ParenTarget(), all)
+	case parser.NodeTypeExists, parser.NodeTypeForall:
+		return v.quantify(node, all)
+	case parser.NodeTypeCall:
+		if node.Callee().Type() == parser.NodeTypeIdent && v.predicates[node.Callee().Ident()] != nil {
+			return v.call(node, all)
+		}
+		return v.test(node, all, true)
+	case parser.NodeTypeSelector, parser.NodeTypeIsNull, parser.NodeTypeIn, parser.NodeTypeIf, parser.NodeTypeCase, parser.NodeTypeBool, parser.NodeTypeParam, parser.NodeTypeNull, parser.NodeTypeAggregate:
+		return v.test(node, all, true)
 	case parser.NodeTypeUnary:
-		s := v.EvalSet(node.UnaryTarget(), all)
-		m := make(map[int]struct{})
-		result := make([]*Record[T], 0, len(all)-len(s))
-		for _, record := range s {
-			if _, ok := m[record.id]; !ok {
-				result = append(result, record)
-			}
+		if node.Op() != "!" && node.Op() != "not" {
+			panic(newTypeError(node, "operator %s used as a condition", node.Op()))
 		}
-		return result
+		return v.evalFalse(node.UnaryTarget(), all)
 	case parser.NodeTypeBinary:
 		if node.Op() == "and" {
 			lhs := v.EvalSet(node.BinaryLhs(), all)
@@ -72,84 +332,561 @@ func (v *Evaluator[T]) EvalSet(node *parser.Node, all []*Record[T]) []*Record[T]
 			return result
 		} else if node.Op() == "or" {
 			lhs := v.EvalSet(node.BinaryLhs(), all)
-			rhs := v.EvalSet(node.BinaryRhs(), all)
-			m := make(map[int]struct{})
-			result := make([]*Record[T], 0, len(lhs)+len(rhs))
-			for _, record := range lhs {
-				m[record.id] = struct{}{}
-				result = append(result, record)
-			}
-			for _, record := range rhs {
-				if _, ok := m[record.id]; !ok {
-					result = append(result, record)
+			rhs := v.EvalSet(node.BinaryRhs(), v.minus(all, lhs))
+			return union(all, lhs, rhs)
+		} else if isCompareOp(node.Op()) {
+			return v.test(node, all, true)
+		} else {
+			panic(newTypeError(node, "operator %s used as a condition", node.Op()))
+		}
+	default:
+		panic(newTypeError(node, "%s used as a condition", node.Type()))
+	}
+}
+
+// evalFalse returns the bindings of all for which node is false. Together with
+// EvalSet this gives three-valued logic: a binding in neither set is unknown,
+// which happens when a comparison involves null.
+func (v *Evaluator[T]) evalFalse(node *parser.Node, all []*Binding[T]) []*Binding[T] {
+	switch node.Type() {
+	case parser.NodeTypeParen:
+		return v.evalFalse(node.ParenTarget(), all)
+	case parser.NodeTypeCall:
+		if node.Callee().Type() == parser.NodeTypeIdent && v.predicates[node.Callee().Ident()] != nil {
+			break
+		}
+		return v.test(node, all, false)
+	case parser.NodeTypeSelector, parser.NodeTypeIsNull, parser.NodeTypeIn, parser.NodeTypeIf, parser.NodeTypeCase, parser.NodeTypeBool, parser.NodeTypeParam, parser.NodeTypeNull, parser.NodeTypeAggregate:
+		return v.test(node, all, false)
+	case parser.NodeTypeUnary:
+		if node.Op() == "!" || node.Op() == "not" {
+			return v.EvalSet(node.UnaryTarget(), all)
+		}
+	case parser.NodeTypeBinary:
+		if node.Op() == "and" {
+			lhs := v.evalFalse(node.BinaryLhs(), all)
+			rhs := v.evalFalse(node.BinaryRhs(), v.minus(all, lhs))
+			return union(all, lhs, rhs)
+		} else if node.Op() == "or" {
+			lhs := v.evalFalse(node.BinaryLhs(), all)
+			return v.evalFalse(node.BinaryRhs(), lhs)
+		} else if isCompareOp(node.Op()) {
+			return v.test(node, all, false)
+		}
+	}
+	// quantifiers and predicate calls are never unknown
+	return v.minus(all, v.EvalSet(node, all))
+}
+
+// union returns the bindings of all that are in lhs or rhs, preserving order.
+func union[T any](all, lhs, rhs []*Binding[T]) []*Binding[T] {
+	m := make(map[*Binding[T]]struct{}, len(lhs)+len(rhs))
+	for _, b := range lhs {
+		m[b] = struct{}{}
+	}
+	for _, b := range rhs {
+		m[b] = struct{}{}
+	}
+	return filter(all, m, true)
+}
+
+// test keeps the bindings for which a comparison or boolean expression is known
+// and equal to want.
+func (v *Evaluator[T]) test(node *parser.Node, all []*Binding[T], want bool) []*Binding[T] {
+	cond := v.condition(node)
+	result := make([]*Binding[T], 0, len(all))
+	for _, b := range all {
+		if ret, ok := cond(b); ok && ret == want {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// condition compiles a comparison or boolean expression into a function
+// returning its result for a binding, and false as second result if it is
+// unknown.
+func (v *Evaluator[T]) condition(node *parser.Node) func(*Binding[T]) (bool, bool) {
+	if node.Type() == parser.NodeTypeBinary && isCompareOp(node.Op()) {
+		op := node.Op()
+		lhs := v.EvalValue(node.BinaryLhs())
+		rhs := v.EvalValue(node.BinaryRhs())
+		if op == "=~" || op == "matches" {
+			return func(b *Binding[T]) (bool, bool) {
+				s, pattern := lhs(b), rhs(b)
+				if s.IsNull() || pattern.IsNull() {
+					return false, false
+				}
+				if s.Type() != ValueTypeString || pattern.Type() != ValueTypeString {
+					panic(newTypeError(node, "cannot apply %s to %s and %s", op, s.Type(), pattern.Type()))
 				}
+				re, err := v.regexps.compile(pattern.StringValue())
+				if err != nil {
+					panic(newEvalError(node.BinaryRhs(), "%v", err))
+				}
+				return re.MatchString(s.StringValue()), true
 			}
-			return result
-		} else if node.Op() == ">" || node.Op() == "<" || node.Op() == ">=" || node.Op() == "<=" || node.Op() == "==" || node.Op() == "!=" {
-			lhs := v.EvalValue(node.BinaryLhs())
-			rhs := v.EvalValue(node.BinaryRhs())
-			if lhs == nil {
-				log.FatalLog("invalid lhs")
-				return nil
+		}
+		return func(b *Binding[T]) (bool, bool) {
+			x, y := lhs(b), rhs(b)
+			if x.IsNull() || y.IsNull() {
+				return false, false
 			}
-			if rhs == nil {
-				log.FatalLog("invalid rhs")
-				return nil
+			return v.compare(node, op, x, y), true
+		}
+	}
+	switch node.Type() {
+	case parser.NodeTypeParen:
+		return v.condition(node.ParenTarget())
+	case parser.NodeTypeUnary:
+		if node.Op() == "!" || node.Op() == "not" {
+			x := v.condition(node.UnaryTarget())
+			return func(b *Binding[T]) (bool, bool) {
+				ret, ok := x(b)
+				return !ret, ok
 			}
-			result := make([]*Record[T], 0, len(all))
-			for _, record := range all {
-				lhsValue := lhs(record.Entity())
-				rhsValue := rhs(record.Entity())
-				if v.compare(node.Op(), lhsValue, rhsValue) {
-					result = append(result, record)
+		}
+	case parser.NodeTypeBinary:
+		if node.Op() == "and" || node.Op() == "or" {
+			// a known lhs equal to short decides the result
+			short := node.Op() == "or"
+			lhs := v.condition(node.BinaryLhs())
+			rhs := v.condition(node.BinaryRhs())
+			return func(b *Binding[T]) (bool, bool) {
+				x, xok := lhs(b)
+				if xok && x == short {
+					return short, true
+				}
+				y, yok := rhs(b)
+				if yok && y == short {
+					return short, true
 				}
+				return !short, xok && yok
 			}
-			return result
-		} else {
-			log.FatalLog("invalid operator %s", node.Op())
-			return nil
 		}
-	default:
-		log.FatalLog("invalid node type %s", node.Type())
-		return nil
+	case parser.NodeTypeExists, parser.NodeTypeForall:
+		return v.single(node)
+	case parser.NodeTypeCall:
+		if node.Callee().Type() == parser.NodeTypeIdent && v.predicates[node.Callee().Ident()] != nil {
+			return v.single(node)
+		}
+	}
+	value := v.EvalValue(node)
+	return func(b *Binding[T]) (bool, bool) {
+		x := value(b)
+		if x.IsNull() {
+			return false, false
+		}
+		if x.Type() != ValueTypeBool {
+			panic(newTypeError(node, "condition of type %s", x.Type()))
+		}
+		return x.BoolValue(), true
+	}
+}
+
+// single evaluates a quantifier or predicate call, which is never unknown, for
+// one binding at a time.
+func (v *Evaluator[T]) single(node *parser.Node) func(*Binding[T]) (bool, bool) {
+	return func(b *Binding[T]) (bool, bool) {
+		return len(v.EvalSet(node, []*Binding[T]{b})) > 0, true
 	}
 }
 
-func (v *Evaluator[T]) EvalValue(node *parser.Node) func(*T) *Value {
+// isLogical reports whether node is a comparison, logical operator or quantifier,
+// whose value is that of its condition.
+func isLogical(node *parser.Node) bool {
+	switch node.Type() {
+	case parser.NodeTypeExists, parser.NodeTypeForall:
+		return true
+	case parser.NodeTypeUnary:
+		return node.Op() == "!" || node.Op() == "not"
+	case parser.NodeTypeBinary:
+		return node.Op() == "and" || node.Op() == "or" || isCompareOp(node.Op())
+	}
+	return false
+}
+
+func isCompareOp(op string) bool {
+	switch op {
+	case ">", "<", ">=", "<=", "==", "!=", "=~", "matches":
+		return true
+	}
+	return false
+}
+
+// callBuiltin compiles a call of a built-in function, either f(x, ...) or x.f(...).
+func (v *Evaluator[T]) callBuiltin(node *parser.Node) func(*Binding[T]) *Value {
+	var name string
+	args := node.Args()
+	callee := node.Callee()
+	if callee.Type() == parser.NodeTypeIdent {
+		name = callee.Ident()
+	} else if callee.Type() == parser.NodeTypeSelector && callee.SelectorTarget() != nil {
+		name = callee.SelectorKey()
+		args = append([]*parser.Node{callee.SelectorTarget()}, args...)
+	} else {
+		panic(newEvalError(callee, "%s is not a function", callee.Type()))
+	}
+	f := builtins[name]
+	if f == nil {
+		panic(newEvalError(callee, "function %s not found", name))
+	}
+	if len(args) < f.minArgs || len(args) > f.maxArgs {
+		panic(newEvalError(node, "invalid number of arguments to %s: %d", name, len(args)))
+	}
+	getters := make([]func(*Binding[T]) *Value, len(args))
+	for i, arg := range args {
+		getters[i] = v.EvalValue(arg)
+	}
+	return func(b *Binding[T]) *Value {
+		values := make([]*Value, len(getters))
+		for i, getter := range getters {
+			if values[i] = getter(b); values[i].IsNull() && !f.nullable {
+				return nil
+			}
+		}
+		defer locate(node)
+		return f.fn(v.regexps, values)
+	}
+}
+
+func (v *Evaluator[T]) EvalValue(node *parser.Node) func(*Binding[T]) *Value {
 	if node.Type() == parser.NodeTypeSelector {
-		if node.SelectorTarget().Type() == parser.NodeTypeIdent {
-			n := node.SelectorTarget().Ident()
-			if n != v.varName {
-				log.FatalLog("invalid identifier %s", n)
+		target := node.SelectorTarget()
+		if target == nil {
+			panic(newEvalError(node, "selector .%s without target", node.SelectorKey()))
+		} else if target.Type() == parser.NodeTypeIdent {
+			n := target.Ident()
+			slot := v.lookup(n)
+			if slot < 0 {
+				panic(newEvalError(target, "variable %s not found", n))
+			}
+			getter := v.vars[slot].table.Getter(node.SelectorKey())
+			if getter == nil {
+				panic(newEvalError(node, "field %s of %s not found", node.SelectorKey(), n))
+			}
+			return func(b *Binding[T]) *Value {
+				if b.records[slot] == nil {
+					return nil
+				}
+				return getter(b.records[slot].Entity())
+			}
+		}
+		// navigate from the entity the target evaluates to
+		x := v.EvalValue(target)
+		getter := v.db.GetBaseTable().Getter(node.SelectorKey())
+		if getter == nil {
+			panic(newEvalError(node, "field %s not found", node.SelectorKey()))
+		}
+		return func(b *Binding[T]) *Value {
+			value := x(b)
+			if value.IsNull() {
 				return nil
 			}
-			getter := v.table.Getter(node.SelectorKey())
-			return getter
-		} else {
-			log.FatalLog("invalid selector target %s", node.SelectorTarget().Type())
+			e, ok := value.EntityValue().(*T)
+			if !ok {
+				panic(newTypeError(target, "selector .%s on %s", node.SelectorKey(), value.Type()))
+			}
+			return getter(e)
+		}
+	} else if node.Type() == parser.NodeTypeIdent {
+		n := node.Ident()
+		slot := v.lookup(n)
+		if slot < 0 {
+			panic(newEvalError(node, "variable %s not found", n))
+		}
+		return func(b *Binding[T]) *Value {
+			return NewEntityValue(b.records[slot].Entity())
+		}
+	} else if node.Type() == parser.NodeTypeParen {
+		return v.EvalValue(node.ParenTarget())
+	} else if node.Type() == parser.NodeTypeAggregate {
+		return v.aggregate(node)
+	} else if node.Type() == parser.NodeTypeCall {
+		return v.callBuiltin(node)
+	} else if node.Type() == parser.NodeTypeNull {
+		return func(b *Binding[T]) *Value {
+			return nil
+		}
+	} else if node.Type() == parser.NodeTypeIsNull {
+		x := v.EvalValue(node.IsNullTarget())
+		negated := node.IsNullNegated()
+		return func(b *Binding[T]) *Value {
+			return NewBoolValue(x(b).IsNull() != negated)
+		}
+	} else if node.Type() == parser.NodeTypeIn {
+		return v.in(node)
+	} else if isLogical(node) {
+		cond := v.condition(node)
+		return func(b *Binding[T]) *Value {
+			if ret, ok := cond(b); ok {
+				return NewBoolValue(ret)
+			}
 			return nil
 		}
+	} else if node.Type() == parser.NodeTypeIf {
+		cond := v.condition(node.IfCond())
+		then := v.EvalValue(node.IfThen())
+		else_ := v.EvalValue(node.IfElse())
+		return func(b *Binding[T]) *Value {
+			if ret, ok := cond(b); ok && ret {
+				return then(b)
+			}
+			return else_(b)
+		}
+	} else if node.Type() == parser.NodeTypeCase {
+		return v.switchCase(node)
+	} else if node.Type() == parser.NodeTypeUnary && node.Op() == "-" {
+		x := v.EvalValue(node.UnaryTarget())
+		return func(b *Binding[T]) *Value {
+			return v.arith(node, "-", NewIntValue(0), x(b))
+		}
+	} else if node.Type() == parser.NodeTypeBinary && isArithOp(node.Op()) {
+		op := node.Op()
+		lhs := v.EvalValue(node.BinaryLhs())
+		rhs := v.EvalValue(node.BinaryRhs())
+		return func(b *Binding[T]) *Value {
+			return v.arith(node, op, lhs(b), rhs(b))
+		}
 	} else if node.Type() == parser.NodeTypeString {
-		return func(entity *T) *Value {
-			s := strings.Trim(node.String(), "'")
-			s = strings.ReplaceAll(s, "\\'", "'")
-			return NewStringValue(s)
+		s, err := parser.Unquote(node.String())
+		if err != nil {
+			panic(newEvalError(node, "%v", err))
+		}
+		value := NewStringValue(s)
+		return func(b *Binding[T]) *Value {
+			return value
+		}
+	} else if node.Type() == parser.NodeTypeParam {
+		value := v.params[node.Param()]
+		return func(b *Binding[T]) *Value {
+			return value
+		}
+	} else if node.Type() == parser.NodeTypeBool {
+		value := NewBoolValue(node.Bool())
+		return func(b *Binding[T]) *Value {
+			return value
 		}
 	} else if node.Type() == parser.NodeTypeNumber {
-		return func(entity *T) *Value {
-			i, _ := strconv.Atoi(node.String())
-			return NewIntValue(i)
+		var value *Value
+		if parser.IsFloat(node.Number()) {
+			f, err := parser.ParseFloat(node.Number())
+			if err != nil {
+				panic(newEvalError(node, "%v", err))
+			}
+			value = NewFloatValue(f)
+		} else {
+			i, err := parser.ParseInt(node.Number())
+			if err != nil {
+				panic(newEvalError(node, "%v", err))
+			}
+			value = NewIntValue(i)
+		}
+		return func(b *Binding[T]) *Value {
+			return value
 		}
 	}
-	log.FatalLog("invalid node type %s", node.Type())
-	return nil
+	panic(newTypeError(node, "%s used as a value", node.Type()))
 }
 
-func (v *Evaluator[T]) compare(op string, lhs, rhs *Value) bool {
-	if lhs.type_ != rhs.type_ {
-		log.FatalLog("invalid lhs, rhs %s %s %s", lhs.type_, rhs.type_, op)
+// in compiles a set membership test. The set of an uncorrelated subquery or a
+// list of constants is computed once and probed by key; other lists are
+// compared item by item. Like a chain of ==, the result is unknown if x is
+// null, or if x is not found and the set contains null.
+func (v *Evaluator[T]) in(node *parser.Node) func(*Binding[T]) *Value {
+	x := v.EvalValue(node.InTarget())
+	negated := node.InNegated()
+	result := func(found, hasNull bool) *Value {
+		if !found && hasNull {
+			return nil
+		}
+		return NewBoolValue(found != negated)
+	}
+	set := node.InSet()
+	var values []*Value
+	if set.Type() == parser.NodeTypeQuery {
+		rows := v.sub().query(set).rows
+		if len(rows.Columns()) != 1 {
+			panic(newEvalError(set, "subquery selects %d columns", len(rows.Columns())))
+		}
+		for _, row := range rows.Rows() {
+			values = append(values, row[0])
+		}
+	} else {
+		items := set.ListItems()
+		getters := make([]func(*Binding[T]) *Value, len(items))
+		constant := true
+		for i, item := range items {
+			getters[i] = v.EvalValue(item)
+			constant = constant && isConstant(item)
+		}
+		if !constant {
+			return func(b *Binding[T]) *Value {
+				lhs := x(b)
+				if lhs.IsNull() {
+					return nil
+				}
+				found, hasNull := false, false
+				for _, getter := range getters {
+					rhs := getter(b)
+					if rhs.IsNull() {
+						hasNull = true
+					} else if v.compare(node, "==", lhs, rhs) {
+						found = true
+						break
+					}
+				}
+				return result(found, hasNull)
+			}
+		}
+		for _, getter := range getters {
+			values = append(values, getter(nil))
+		}
+	}
+	keys := make(map[string]struct{}, len(values))
+	hasNull := false
+	for _, value := range values {
+		if value.IsNull() {
+			hasNull = true
+		} else {
+			keys[value.Key()] = struct{}{}
+		}
+	}
+	return func(b *Binding[T]) *Value {
+		lhs := x(b)
+		if lhs.IsNull() {
+			return nil
+		}
+		_, found := keys[lhs.Key()]
+		return result(found, hasNull)
+	}
+}
+
+// switchCase compiles a case expression, whose result is that of the first when
+// with a true condition, or else that of the else, or null without an else.
+// Like in a where clause, a condition that is unknown counts as false.
+func (v *Evaluator[T]) switchCase(node *parser.Node) func(*Binding[T]) *Value {
+	whens := node.CaseWhens()
+	conds := make([]func(*Binding[T]) (bool, bool), len(whens))
+	results := make([]func(*Binding[T]) *Value, len(whens))
+	for i, when := range whens {
+		conds[i] = v.condition(when.WhenCond())
+		results[i] = v.EvalValue(when.WhenResult())
+	}
+	else_ := func(b *Binding[T]) *Value {
+		return nil
+	}
+	if node.CaseElse() != nil {
+		else_ = v.EvalValue(node.CaseElse())
+	}
+	return func(b *Binding[T]) *Value {
+		for i, cond := range conds {
+			if ret, ok := cond(b); ok && ret {
+				return results[i](b)
+			}
+		}
+		return else_(b)
+	}
+}
+
+// isConstant reports whether node is built from literals and parameters only.
+func isConstant(node *parser.Node) bool {
+	switch node.Type() {
+	case parser.NodeTypeNumber, parser.NodeTypeString, parser.NodeTypeNull, parser.NodeTypeBool, parser.NodeTypeParam:
+		return true
+	case parser.NodeTypeParen:
+		return isConstant(node.ParenTarget())
+	case parser.NodeTypeUnary:
+		return node.Op() == "-" && isConstant(node.UnaryTarget())
+	case parser.NodeTypeBinary:
+		return isArithOp(node.Op()) && isConstant(node.BinaryLhs()) && isConstant(node.BinaryRhs())
+	}
+	return false
+}
+
+func isArithOp(op string) bool {
+	return op == "+" || op == "-" || op == "*" || op == "/" || op == "%"
+}
+
+// arith applies an arithmetic operator; node is the expression, for errors.
+func (v *Evaluator[T]) arith(node *parser.Node, op string, lhs, rhs *Value) *Value {
+	if lhs.IsNull() || rhs.IsNull() {
+		return nil
+	}
+	if lhs.type_ == ValueTypeString && rhs.type_ == ValueTypeString && op == "+" {
+		return NewStringValue(lhs.StringValue() + rhs.StringValue())
+	}
+	if !lhs.IsNumber() || !rhs.IsNumber() {
+		panic(newTypeError(node, "cannot apply %s to %s and %s", op, lhs.type_, rhs.type_))
+	}
+	if lhs.type_ == ValueTypeFloat || rhs.type_ == ValueTypeFloat {
+		x, y := lhs.NumberValue(), rhs.NumberValue()
+		switch op {
+		case "+":
+			return NewFloatValue(x + y)
+		case "-":
+			return NewFloatValue(x - y)
+		case "*":
+			return NewFloatValue(x * y)
+		case "/", "%":
+			if y == 0 {
+				panic(newEvalError(node, "division by zero"))
+			}
+			if op == "/" {
+				return NewFloatValue(x / y)
+			}
+			return NewFloatValue(math.Mod(x, y))
+		}
+		panic(newEvalError(node, "invalid operator %s", op))
+	}
+	switch op {
+	case "+":
+		return NewIntValue(lhs.IntValue() + rhs.IntValue())
+	case "-":
+		return NewIntValue(lhs.IntValue() - rhs.IntValue())
+	case "*":
+		return NewIntValue(lhs.IntValue() * rhs.IntValue())
+	case "/", "%":
+		if rhs.IntValue() == 0 {
+			panic(newEvalError(node, "division by zero"))
+		}
+		if op == "/" {
+			return NewIntValue(lhs.IntValue() / rhs.IntValue())
+		}
+		return NewIntValue(lhs.IntValue() % rhs.IntValue())
+	}
+	panic(newEvalError(node, "invalid operator %s", op))
+}
+
+// compare applies a comparison operator to values that are not null; node is
+// the expression, for errors.
+func (v *Evaluator[T]) compare(node *parser.Node, op string, lhs, rhs *Value) bool {
+	if lhs.IsNull() || rhs.IsNull() {
+		// callers decide what a comparison with null means
 		return false
 	}
+	if lhs.IsNumber() && rhs.IsNumber() && (lhs.type_ == ValueTypeFloat || rhs.type_ == ValueTypeFloat) {
+		x, y := lhs.NumberValue(), rhs.NumberValue()
+		switch op {
+		case ">":
+			return x > y
+		case "<":
+			return x < y
+		case ">=":
+			return x >= y
+		case "<=":
+			return x <= y
+		case "==":
+			return x == y
+		case "!=":
+			return x != y
+		default:
+			panic(newEvalError(node, "invalid operator %s", op))
+		}
+	}
+	if lhs.type_ != rhs.type_ {
+		panic(newTypeError(node, "cannot apply %s to %s and %s", op, lhs.type_, rhs.type_))
+	}
 	if lhs.type_ == ValueTypeInt {
 		switch op {
 		case ">":
@@ -162,18 +899,41 @@ func (v *Evaluator[T]) compare(op string, lhs, rhs *Value) bool {
 			return lhs.IntValue() <= rhs.IntValue()
 		case "==":
 			return lhs.IntValue() == rhs.IntValue()
+		case "!=":
+			return lhs.IntValue() != rhs.IntValue()
 		default:
-			log.FatalLog("invalid op %s", op)
-			return false
+			panic(newEvalError(node, "invalid operator %s", op))
 		}
 	}
-	if lhs.type_ == ValueTypeString {
+	if lhs.type_ == ValueTypeEntity {
 		if op == "==" {
-			return lhs.StringValue() == rhs.StringValue()
+			return lhs.EntityValue() == rhs.EntityValue()
+		} else if op == "!=" {
+			return lhs.EntityValue() != rhs.EntityValue()
+		}
+	}
+	if lhs.type_ == ValueTypeBool {
+		if op == "==" {
+			return lhs.BoolValue() == rhs.BoolValue()
 		} else if op == "!=" {
+			return lhs.BoolValue() != rhs.BoolValue()
+		}
+	}
+	if lhs.type_ == ValueTypeString {
+		switch op {
+		case ">":
+			return lhs.StringValue() > rhs.StringValue()
+		case "<":
+			return lhs.StringValue() < rhs.StringValue()
+		case ">=":
+			return lhs.StringValue() >= rhs.StringValue()
+		case "<=":
+			return lhs.StringValue() <= rhs.StringValue()
+		case "==":
+			return lhs.StringValue() == rhs.StringValue()
+		case "!=":
 			return lhs.StringValue() != rhs.StringValue()
 		}
 	}
-	log.FatalLog("invalid op %s", op)
-	return false
+	panic(newTypeError(node, "cannot apply %s to %s and %s", op, lhs.type_, rhs.type_))
 }
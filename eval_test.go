@@ -0,0 +1,201 @@
+package ql
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testEntity struct {
+	num int
+	par *testEntity
+}
+
+// newTestDatabase returns a database of n entities numbered from 0, where the
+// parent of entity i is entity (i-1)/2, so that they form a binary tree.
+func newTestDatabase(n int) *Database[testEntity] {
+	entities := make([]*testEntity, n)
+	for i := range entities {
+		entities[i] = &testEntity{num: i}
+		if i > 0 {
+			entities[i].par = entities[(i-1)/2]
+		}
+	}
+	db := NewDatabase[testEntity](entities)
+	table := db.GetBaseTable()
+	table.Define("num", ValueTypeInt, func(e *testEntity) *Value {
+		return NewIntValue(e.num)
+	})
+	table.Define("par", ValueTypeEntity, func(e *testEntity) *Value {
+		return NewEntityValue(e.par)
+	})
+	return db
+}
+
+// selectRows runs q against db and returns its rows as strings.
+func selectRows(t *testing.T, db *Database[testEntity], q string) [][]string {
+	t.Helper()
+	rs, err := db.Select(q)
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	rows := make([][]string, rs.Len())
+	for i, row := range rs.Rows() {
+		rows[i] = make([]string, len(row))
+		for j, value := range row {
+			rows[i][j] = value.String()
+		}
+	}
+	return rows
+}
+
+func TestJoin(t *testing.T) {
+	db := newTestDatabase(3000)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{
+			"select Entity a, Entity b where a.num == 5 and b.par == a select a.num, b.num",
+			[][]string{{"5", "11"}, {"5", "12"}},
+		},
+		{
+			// bindings keep the order of the cartesian product
+			"select Entity a, Entity b where a.num < 3 and b.num < 2 and a.num != b.num select a.num, b.num",
+			[][]string{{"0", "1"}, {"1", "0"}, {"2", "0"}, {"2", "1"}},
+		},
+		{
+			"select Entity a, Entity b, Entity c where c.par == b and b.par == a and a.num == 1 and c.num % 2 == 0 select c.num",
+			[][]string{{"8"}, {"10"}},
+		},
+		{
+			"select Entity a where 1 > 2 and a.num == 1 select a.num",
+			[][]string{},
+		},
+		{
+			// a condition on the root's missing parent is unknown, not true
+			"select Entity a where not (a.par.num > 0) and a.num < 5 select a.num",
+			[][]string{{"1"}, {"2"}},
+		},
+		{
+			// the root's missing parent is equal to no other
+			"select Entity a, Entity b where a.par == b.par and a.num < 3 and a.num != b.num select a.num, b.num",
+			[][]string{{"1", "2"}, {"2", "1"}},
+		},
+	}
+	for _, test := range tests {
+		got := selectRows(t, db, test.query)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestQuantify(t *testing.T) {
+	db := newTestDatabase(3000)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{
+			"select Entity n where exists(Entity m | m.par == n and m.num > 2990) select n.num",
+			[][]string{{"1495"}, {"1496"}, {"1497"}, {"1498"}, {"1499"}},
+		},
+		{
+			"select Entity n where n.num < 4 and exists(Entity m, Entity k | k.par == m and m.par == n and k.num % 4 == 0) select n.num",
+			[][]string{{"0"}, {"1"}, {"2"}, {"3"}},
+		},
+		{
+			"select Entity n where n.num > 1490 and n.num < 1502 and forall(Entity m | m.par == n and m.num > 2990 | m.num % 2 == 1) select n.num",
+			[][]string{{"1491"}, {"1492"}, {"1493"}, {"1494"}, {"1499"}, {"1500"}, {"1501"}},
+		},
+		{
+			// the condition on the outer variable alone decides for every extension
+			"select Entity n where n.num < 3 and exists(Entity m | n.num == 1 and m.num == 0) select n.num",
+			[][]string{{"1"}},
+		},
+	}
+	for _, test := range tests {
+		if got := selectRows(t, db, test.query); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestInCorrelated(t *testing.T) {
+	db := newTestDatabase(10)
+	q := "select Entity n where n.num in (select Entity m where m.par == n select m.num) select n.num"
+	_, err := db.Select(q)
+	var e *EvalError
+	if !errors.As(err, &e) || !strings.Contains(e.Error(), "correlated subqueries are not supported") {
+		t.Errorf("%s: got error %v, want a correlated subquery", q, err)
+	}
+	q = "select Entity n where n.num in (select Entity n where n.num < 2 select n.num + 1) select n.num"
+	want := [][]string{{"1"}, {"2"}}
+	if got := selectRows(t, db, q); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %v, want %v", q, got, want)
+	}
+}
+
+func TestGetterPanic(t *testing.T) {
+	db := newTestDatabase(10)
+	db.GetBaseTable().Define("boom", ValueTypeInt, func(e *testEntity) *Value {
+		panic("boom")
+	})
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("got panic %v, want boom", r)
+		}
+	}()
+	_, err := db.Select("select Entity n select n.boom")
+	t.Errorf("got error %v, want a panic", err)
+}
+
+func TestParamCondition(t *testing.T) {
+	db := newTestDatabase(10)
+	tests := []struct {
+		query string
+		flag  *Value
+		want  [][]string
+	}{
+		{"select Entity n where $flag and n.num < 2 select n.num", NewBoolValue(true), [][]string{{"0"}, {"1"}}},
+		{"select Entity n where $flag and n.num < 2 select n.num", NewBoolValue(false), [][]string{}},
+		// a null parameter is unknown, and so is its negation
+		{"select Entity n where $flag and n.num < 2 select n.num", nil, [][]string{}},
+		{"select Entity n where not $flag and n.num < 2 select n.num", nil, [][]string{}},
+		{"select Entity n where ($flag or n.num < 2) and n.num < 5 select n.num", nil, [][]string{{"0"}, {"1"}}},
+	}
+	for _, test := range tests {
+		rs, err := db.SelectWith(test.query, map[string]*Value{"flag": test.flag})
+		if err != nil {
+			t.Errorf("%s with %v: %v", test.query, test.flag, err)
+			continue
+		}
+		got := make([][]string, 0, rs.Len())
+		for _, row := range rs.Rows() {
+			got = append(got, []string{row[0].String()})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s with %v = %v, want %v", test.query, test.flag, got, test.want)
+		}
+	}
+}
+
+func TestNullCondition(t *testing.T) {
+	db := newTestDatabase(10)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"select Entity n where null select n.num", [][]string{}},
+		{"select Entity n where not null select n.num", [][]string{}},
+		{"select Entity n where null or n.num < 2 select n.num", [][]string{{"0"}, {"1"}}},
+		{"select Entity n where not (null and n.num > 1) select n.num", [][]string{{"0"}, {"1"}}},
+	}
+	for _, test := range tests {
+		if got := selectRows(t, db, test.query); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
@@ -15,7 +15,7 @@ func main() {
 	}
 	db := ql.NewDatabase[Entity](entities)
 	tbl := db.GetTable("")
-	tbl.Define("num", func(e *Entity) *ql.Value {
+	tbl.Define("num", ql.ValueTypeInt, func(e *Entity) *ql.Value {
 		return ql.NewIntValue(e.num)
 	})
 	_, err := db.AddTable("", "OddNumber", nil, func(t *Entity) []string {
@@ -25,12 +25,18 @@ func main() {
 	type Entity = parser.Node
 	db := ql.NewDatabase[Entity](entities)
 	tbl := db.GetBaseTable()
-	tbl.Define("op", func(e *Entity) *ql.Value {
+	tbl.Define("op", ql.ValueTypeString, func(e *Entity) *ql.Value {
 		return ql.NewStringValue(e.Op())
 	})
-	tbl.Define("type", func(e *Entity) *ql.Value {
+	tbl.Define("type", ql.ValueTypeString, func(e *Entity) *ql.Value {
 		return ql.NewStringValue(e.Type())
 	})
+	tbl.Define("lhs", ql.ValueTypeEntity, func(e *Entity) *ql.Value {
+		return ql.NewEntityValue(e.BinaryLhs())
+	})
+	tbl.Define("rhs", ql.ValueTypeEntity, func(e *Entity) *ql.Value {
+		return ql.NewEntityValue(e.BinaryRhs())
+	})
 	_, err = db.AddTable("Entity", "BinaryExpr", nil, func(t *Entity) []string {
 		if t.Type() == parser.NodeTypeBinary {
 			return []string{}
@@ -51,7 +57,7 @@ func main() {
 		log.ErrorLog("fail to add table: %v", err)
 		return
 	}
-	ret, err := db.Query(`select Entity n where n.type == 'binary' and n.op == '!='`)
+	ret, err := db.Query(`select BinaryExpr n where n.lhs.type == 'binary' and n.lhs.op == '=='`)
 	if err != nil {
 		log.ErrorLog("fail to query: %v", err)
 		return
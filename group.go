@@ -0,0 +1,110 @@
+package ql
+
+import (
+	"github.com/lincaiyong/ql/parser"
+	"strings"
+)
+
+// grouped reports whether the rows of query are groups of bindings, either
+// because of a group by clause or because it selects aggregates over the rows.
+func grouped(query *parser.Node) bool {
+	if query.QueryGroupBy() != nil {
+		return true
+	}
+	ret := false
+	items := append([]*parser.Node(nil), query.QuerySelect()...)
+	for _, item := range query.QueryOrderBy() {
+		items = append(items, item.OrderItemExpr())
+	}
+	for _, item := range items {
+		item.Visit(func(n *parser.Node) {
+			if n.Type() == parser.NodeTypeAggregate && n.AggregateDecls() == nil {
+				ret = true
+			}
+		})
+	}
+	return ret
+}
+
+// group partitions all by the group by keys of query, in order of first
+// occurrence. Without grouping every binding is a group of its own, and
+// aggregates over the rows without group by make exactly one group, empty if
+// there are no rows.
+func (v *Evaluator[T]) group(query *parser.Node, all []*Binding[T]) [][]*Binding[T] {
+	if !grouped(query) {
+		result := make([][]*Binding[T], len(all))
+		for i, b := range all {
+			result[i] = []*Binding[T]{b}
+		}
+		return result
+	}
+	keys := query.QueryGroupBy()
+	if keys == nil && len(all) == 0 {
+		return [][]*Binding[T]{{}}
+	}
+	getters := make([]func(*Binding[T]) *Value, len(keys))
+	for i, key := range keys {
+		getters[i] = v.EvalValue(key)
+	}
+	var result [][]*Binding[T]
+	m := make(map[string]int)
+	for _, b := range all {
+		var sb strings.Builder
+		for _, getter := range getters {
+			sb.WriteString(getter(b).Key())
+			sb.WriteByte(',')
+		}
+		key := sb.String()
+		if i, ok := m[key]; ok {
+			result[i] = append(result[i], b)
+		} else {
+			m[key] = len(result)
+			result = append(result, []*Binding[T]{b})
+		}
+	}
+	return result
+}
+
+// first returns the binding representing group g, which binds no records if the
+// group is empty.
+func (v *Evaluator[T]) first(g []*Binding[T]) *Binding[T] {
+	if len(g) == 0 {
+		return &Binding[T]{records: make([]*Record[T], len(v.vars))}
+	}
+	return g[0]
+}
+
+// groupAggregate compiles an aggregate over the bindings of the group being
+// projected.
+func (v *Evaluator[T]) groupAggregate(node *parser.Node) func(*Binding[T]) *Value {
+	name := node.AggregateName()
+	var expr, sep func(*Binding[T]) *Value
+	if name != "count" {
+		expr = v.EvalValue(node.AggregateExpr())
+	}
+	if node.AggregateSeparator() != nil {
+		sep = v.EvalValue(node.AggregateSeparator())
+	}
+	return func(b *Binding[T]) *Value {
+		if v.rows == nil {
+			panic(newEvalError(node, "aggregate %s without variables outside of a grouped select", name))
+		}
+		if name == "count" {
+			return NewIntValue(len(v.rows))
+		}
+		values := make([]*Value, 0, len(v.rows))
+		for _, row := range v.rows {
+			if value := expr(row); !value.IsNull() {
+				values = append(values, value)
+			}
+		}
+		if name == "concat" {
+			var s string
+			if sep != nil {
+				s = sep(b).StringValue()
+			}
+			return concat(values, s)
+		}
+		return v.reduce(node, values)
+	}
+}
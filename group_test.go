@@ -0,0 +1,45 @@
+package ql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	db := newTestDatabase(10)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{
+			"select n.par.num, count(n), sum(n.num) from Entity n where n.num > 0 group by n.par",
+			[][]string{{"0", "2", "3"}, {"1", "2", "7"}, {"2", "2", "11"}, {"3", "2", "15"}, {"4", "1", "9"}},
+		},
+		{
+			"select count(n), sum(n.num), min(n.num), max(n.num) from Entity n",
+			[][]string{{"10", "45", "0", "9"}},
+		},
+		{
+			// aggregates over no rows still make one row
+			"select count(n), sum(n.num), avg(n.num), max(n.num), n.num from Entity n where n.num > 100",
+			[][]string{{"0", "0", "null", "null", "null"}},
+		},
+		{
+			"select count(n) from Entity n where n.num > 100 group by n.par",
+			[][]string{},
+		},
+		{
+			"select count(n), concat(n.num, ',') from Entity n where n.num > 100 order by count(n)",
+			[][]string{{"0", ""}},
+		},
+		{
+			"predicate p(Entity a) { a.num > 1 } select count(n), if p(n) then 1 else 0 from Entity n where n.num > 100",
+			[][]string{{"0", "0"}},
+		},
+	}
+	for _, test := range tests {
+		if got := selectRows(t, db, test.query); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
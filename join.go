@@ -0,0 +1,265 @@
+package ql
+
+import (
+	"github.com/lincaiyong/ql/parser"
+	"slices"
+)
+
+// join extends every binding of all, which binds the variables before slot n,
+// with the records of the variables from slot n onwards that satisfy conds, a
+// conjunction. The returned map gives the binding of all each result was
+// derived from.
+func (v *Evaluator[T]) join(all []*Binding[T], n int, conds []*parser.Node) ([]*Binding[T], map[*Binding[T]]*Binding[T]) {
+	return v.joiner(n, conds)(all)
+}
+
+// joiner returns a function doing what join does with n and conds. The records
+// of every variable are filtered and indexed once, on first use, so that joining
+// again, as an aggregate does for every outer binding, only costs the lookups.
+func (v *Evaluator[T]) joiner(n int, conds []*parser.Node) func(all []*Binding[T]) ([]*Binding[T], map[*Binding[T]]*Binding[T]) {
+	width := len(v.vars)
+	bound := make([]bool, width)
+	for i := 0; i < n; i++ {
+		bound[i] = true
+	}
+	bind := v.plan(bound, conds)
+	return func(all []*Binding[T]) ([]*Binding[T], map[*Binding[T]]*Binding[T]) {
+		result := make([]*Binding[T], len(all))
+		parents := make(map[*Binding[T]]*Binding[T], len(all))
+		for i, b := range all {
+			rs := make([]*Record[T], width)
+			copy(rs, b.records)
+			result[i] = &Binding[T]{records: rs}
+			parents[result[i]] = b
+		}
+		return bind(result, parents)
+	}
+}
+
+// bind extends every binding of all, which binds the variables of the bound
+// slots, with the records of the other variables that satisfy conds. The
+// bindings of all must have a slot for every variable in scope, and parents
+// maps each to the binding it was derived from.
+func (v *Evaluator[T]) bind(all []*Binding[T], parents map[*Binding[T]]*Binding[T], bound []bool, conds []*parser.Node) ([]*Binding[T], map[*Binding[T]]*Binding[T]) {
+	return v.plan(bound, conds)(all, parents)
+}
+
+// plan returns a function doing what bind does with bound and conds. The
+// variables are bound one at a time: the records of each are first filtered by
+// the conditions on that variable alone, and every other condition is applied
+// as soon as all its variables are bound, so that the cartesian product of the
+// variables is never built.
+func (v *Evaluator[T]) plan(bound []bool, conds []*parser.Node) func(all []*Binding[T], parents map[*Binding[T]]*Binding[T]) ([]*Binding[T], map[*Binding[T]]*Binding[T]) {
+	width := len(v.vars)
+	bound = slices.Clone(bound)
+	slots := make([][]int, len(conds))
+	for i, cond := range conds {
+		slots[i] = v.slots(cond)
+	}
+	applied := make([]bool, len(conds))
+	// ready returns the conditions not applied yet whose variables are all bound
+	ready := func() []*parser.Node {
+		var result []*parser.Node
+		for i, cond := range conds {
+			if !applied[i] && !slices.ContainsFunc(slots[i], func(slot int) bool { return !bound[slot] }) {
+				applied[i] = true
+				result = append(result, cond)
+			}
+		}
+		return result
+	}
+	// a step binds the variable of slot k, then applies the conditions after
+	type step struct {
+		k          int
+		local      []*parser.Node
+		own, other *parser.Node
+		after      []*parser.Node
+		candidates func(*Binding[T]) []*Record[T] // prepared on first use
+	}
+	first := ready()
+	var steps []*step
+	for k := 0; k < width; k++ {
+		if bound[k] {
+			continue
+		}
+		s := &step{k: k}
+		for i, cond := range conds {
+			if !applied[i] && len(slots[i]) == 1 && slots[i][0] == k {
+				applied[i] = true
+				s.local = append(s.local, cond)
+			}
+		}
+		s.own, s.other = v.equality(conds, slots, applied, bound, k)
+		bound[k] = true
+		s.after = ready()
+		steps = append(steps, s)
+	}
+
+	return func(all []*Binding[T], parents map[*Binding[T]]*Binding[T]) ([]*Binding[T], map[*Binding[T]]*Binding[T]) {
+		result := all
+		for _, cond := range first {
+			result = v.EvalSet(cond, result)
+		}
+		for _, s := range steps {
+			if len(result) == 0 {
+				break
+			}
+			if s.candidates == nil {
+				s.candidates = v.candidates(s.k, s.local, s.own, s.other)
+			}
+			next := make([]*Binding[T], 0, len(result))
+			nextParents := make(map[*Binding[T]]*Binding[T], len(result))
+			for _, b := range result {
+				for _, record := range s.candidates(b) {
+					rs := slices.Clone(b.records)
+					rs[s.k] = record
+					e := &Binding[T]{records: rs}
+					nextParents[e] = parents[b]
+					next = append(next, e)
+				}
+			}
+			result, parents = next, nextParents
+			for _, cond := range s.after {
+				result = v.EvalSet(cond, result)
+			}
+		}
+		return result, parents
+	}
+}
+
+// candidates returns a function giving the records of the variable of slot k a
+// binding may be extended with: those satisfying the local conditions, and if
+// own is not nil, for which own has the value other has for the binding. The
+// condition comparing own and other is still to be applied, and so is every
+// other.
+func (v *Evaluator[T]) candidates(k int, local []*parser.Node, own, other *parser.Node) func(*Binding[T]) []*Record[T] {
+	records := v.vars[k].table.Records()
+	if local != nil {
+		bindings := make([]*Binding[T], len(records))
+		for i, record := range records {
+			rs := make([]*Record[T], len(v.vars))
+			rs[k] = record
+			bindings[i] = &Binding[T]{records: rs}
+		}
+		for _, cond := range local {
+			bindings = v.EvalSet(cond, bindings)
+		}
+		records = make([]*Record[T], len(bindings))
+		for i, b := range bindings {
+			records[i] = b.records[k]
+		}
+	}
+	if own == nil {
+		return func(*Binding[T]) []*Record[T] { return records }
+	}
+	return v.index(own, other, k, records)
+}
+
+// equality returns the sides of a condition not applied yet that compares with
+// == an expression of the variable of slot k alone, own, to an expression of
+// variables already bound, other, or nil.
+func (v *Evaluator[T]) equality(conds []*parser.Node, slots [][]int, applied, bound []bool, k int) (own, other *parser.Node) {
+	for i, cond := range conds {
+		if applied[i] || !slices.Contains(slots[i], k) {
+			continue
+		}
+		for cond.Type() == parser.NodeTypeParen {
+			cond = cond.ParenTarget()
+		}
+		if cond.Type() != parser.NodeTypeBinary || cond.Op() != "==" {
+			continue
+		}
+		for _, sides := range [][2]*parser.Node{{cond.BinaryLhs(), cond.BinaryRhs()}, {cond.BinaryRhs(), cond.BinaryLhs()}} {
+			if slices.Equal(v.slots(sides[0]), []int{k}) && !slices.ContainsFunc(v.slots(sides[1]), func(slot int) bool { return !bound[slot] }) {
+				return sides[0], sides[1]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// index returns a function giving the records of the variable of slot k for
+// which own has the value other has for a binding. Null is equal to nothing.
+func (v *Evaluator[T]) index(own, other *parser.Node, k int, records []*Record[T]) func(*Binding[T]) []*Record[T] {
+	key, lookup := v.EvalValue(own), v.EvalValue(other)
+	m := make(map[string][]*Record[T])
+	for _, record := range records {
+		rs := make([]*Record[T], len(v.vars))
+		rs[k] = record
+		if value := key(&Binding[T]{records: rs}); !value.IsNull() {
+			m[value.Key()] = append(m[value.Key()], record)
+		}
+	}
+	return func(b *Binding[T]) []*Record[T] {
+		if value := lookup(b); !value.IsNull() {
+			return m[value.Key()]
+		}
+		return nil
+	}
+}
+
+// conjuncts appends the operands of the conjunction node to conds.
+func conjuncts(node *parser.Node, conds []*parser.Node) []*parser.Node {
+	if node.Type() == parser.NodeTypeParen {
+		return conjuncts(node.ParenTarget(), conds)
+	} else if node.Type() == parser.NodeTypeBinary && node.Op() == "and" {
+		return conjuncts(node.BinaryRhs(), conjuncts(node.BinaryLhs(), conds))
+	}
+	return append(conds, node)
+}
+
+// slots returns the slots of the variables in scope that node refers to, in
+// increasing order.
+func (v *Evaluator[T]) slots(node *parser.Node) []int {
+	var result []int
+	freeVars(node, func(ident *parser.Node) {
+		if slot := v.lookup(ident.Ident()); slot >= 0 && !slices.Contains(result, slot) {
+			result = append(result, slot)
+		}
+	})
+	slices.Sort(result)
+	return result
+}
+
+// freeVars calls f for every identifier in node that may refer to a variable
+// declared outside of node. The names of functions, predicates and tables are
+// skipped, and so are the variables of quantifiers, aggregates and subqueries
+// within node.
+func freeVars(node *parser.Node, f func(ident *parser.Node)) {
+	var visit func(n *parser.Node, inner []string)
+	visit = func(n *parser.Node, inner []string) {
+		var decls []*parser.Node
+		switch n.Type() {
+		case parser.NodeTypeIdent:
+			if !slices.Contains(inner, n.Ident()) {
+				f(n)
+			}
+			return
+		case parser.NodeTypeVarDecl:
+			return
+		case parser.NodeTypeCall:
+			if n.Callee().Type() == parser.NodeTypeIdent {
+				for _, arg := range n.Args() {
+					visit(arg, inner)
+				}
+				return
+			}
+		case parser.NodeTypeExists, parser.NodeTypeForall:
+			decls = n.QuantifierDecls()
+		case parser.NodeTypeAggregate:
+			decls = n.AggregateDecls()
+		case parser.NodeTypeQuery:
+			decls = n.QueryFrom()
+		}
+		if decls != nil {
+			inner = slices.Clip(inner)
+			for _, decl := range decls {
+				inner = append(inner, decl.VarDeclName())
+			}
+		}
+		for _, child := range n.Children() {
+			visit(child, inner)
+		}
+	}
+	visit(node, nil)
+}
@@ -0,0 +1,140 @@
+package ql
+
+import (
+	"container/heap"
+	"github.com/lincaiyong/ql/parser"
+	"sort"
+)
+
+type sortItem[T any] struct {
+	group []*Binding[T]
+	keys  []*Value
+	index int
+}
+
+// sortHeap keeps the worst item on top so that it can be evicted first.
+type sortHeap[T any] struct {
+	items []*sortItem[T]
+	less  func(a, b *sortItem[T]) bool
+}
+
+func (h *sortHeap[T]) Len() int {
+	return len(h.items)
+}
+
+func (h *sortHeap[T]) Less(i, j int) bool {
+	return h.less(h.items[j], h.items[i])
+}
+
+func (h *sortHeap[T]) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *sortHeap[T]) Push(x any) {
+	h.items = append(h.items, x.(*sortItem[T]))
+}
+
+func (h *sortHeap[T]) Pop() any {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
+
+// paginate applies the order by, offset and limit clauses of query to the
+// groups of bindings. With a limit only the first offset+limit groups are kept
+// on a heap instead of sorting all of them.
+func (v *Evaluator[T]) paginate(query *parser.Node, all [][]*Binding[T]) [][]*Binding[T] {
+	offset := v.constInt(query.QueryOffset(), 0)
+	limit := v.constInt(query.QueryLimit(), -1)
+	if items := query.QueryOrderBy(); items != nil {
+		all = v.sort(items, all, offset, limit)
+	}
+	if offset >= len(all) {
+		return nil
+	}
+	all = all[offset:]
+	if limit >= 0 && limit < len(all) {
+		all = all[:limit]
+	}
+	return all
+}
+
+func (v *Evaluator[T]) sort(items []*parser.Node, all [][]*Binding[T], offset, limit int) [][]*Binding[T] {
+	getters := make([]func(*Binding[T]) *Value, len(items))
+	for i, item := range items {
+		getters[i] = v.EvalValue(item.OrderItemExpr())
+	}
+	less := func(a, b *sortItem[T]) bool {
+		for i, item := range items {
+			c := v.order(item, a.keys[i], b.keys[i])
+			if item.OrderItemDesc() {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return a.index < b.index
+	}
+	h := &sortHeap[T]{less: less}
+	k := len(all)
+	if limit >= 0 && offset+limit < k {
+		k = offset + limit
+	}
+	for i, g := range all {
+		v.rows = g
+		keys := make([]*Value, len(getters))
+		for j, getter := range getters {
+			keys[j] = getter(v.first(g))
+		}
+		item := &sortItem[T]{group: g, keys: keys, index: i}
+		if k == len(all) {
+			h.items = append(h.items, item)
+		} else if h.Len() < k {
+			heap.Push(h, item)
+		} else if k > 0 && less(item, h.items[0]) {
+			h.items[0] = item
+			heap.Fix(h, 0)
+		}
+	}
+	v.rows = nil
+	sort.Slice(h.items, func(i, j int) bool {
+		return less(h.items[i], h.items[j])
+	})
+	result := make([][]*Binding[T], len(h.items))
+	for i, item := range h.items {
+		result[i] = item.group
+	}
+	return result
+}
+
+// order compares two values of an order item for sorting. Nulls sort first.
+func (v *Evaluator[T]) order(item *parser.Node, a, b *Value) int {
+	if a.IsNull() || b.IsNull() {
+		if a.IsNull() && b.IsNull() {
+			return 0
+		} else if a.IsNull() {
+			return -1
+		}
+		return 1
+	}
+	if v.compare(item, "<", a, b) {
+		return -1
+	} else if v.compare(item, ">", a, b) {
+		return 1
+	}
+	return 0
+}
+
+// constInt evaluates an expression that does not depend on any variable.
+func (v *Evaluator[T]) constInt(node *parser.Node, default_ int) int {
+	if node == nil {
+		return default_
+	}
+	value := v.EvalValue(node)(&Binding[T]{records: make([]*Record[T], len(v.vars))})
+	if value.IsNull() || value.Type() != ValueTypeInt || value.IntValue() < 0 {
+		panic(newTypeError(node, "expect non-negative int, got %s", value))
+	}
+	return value.IntValue()
+}
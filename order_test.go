@@ -0,0 +1,67 @@
+package ql
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLimitVariables(t *testing.T) {
+	db := newTestDatabase(10)
+	for _, q := range []string{
+		"select Entity n select n.num limit n.num",
+		"select Entity n select n.num offset length('x') + n.num",
+	} {
+		_, err := db.Select(q)
+		var e *EvalError
+		if !errors.As(err, &e) || !strings.Contains(e.Error(), "cannot refer to variable n") {
+			t.Errorf("%s: got error %v, want a reference to variable n", q, err)
+		}
+	}
+	q := "select Entity n select n.num limit count(Entity m | m.num < 3) offset 1"
+	want := [][]string{{"1"}, {"2"}, {"3"}}
+	if got := selectRows(t, db, q); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %v, want %v", q, got, want)
+	}
+}
+
+func TestOrderLimit(t *testing.T) {
+	db := newTestDatabase(50)
+	// with equal keys the rows keep the order they had before sorting
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"select Entity n where n.num < 10 select n.num order by n.num % 3 limit 4", [][]string{{"0"}, {"3"}, {"6"}, {"9"}}},
+		{"select Entity n where n.num < 10 select n.num order by n.num % 3 limit 4 offset 2", [][]string{{"6"}, {"9"}, {"1"}, {"4"}}},
+		{"select Entity n where n.num < 10 select n.num order by n.num % 3 desc limit 3 offset 1", [][]string{{"5"}, {"8"}, {"1"}}},
+		{"select Entity n where n.num < 10 select n.num order by n.num % 3 limit 0", [][]string{}},
+	}
+	for _, test := range tests {
+		if got := selectRows(t, db, test.query); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	// the first rows of the full sort, found on a heap
+	for _, q := range []string{
+		"select Entity n select n.num order by n.num % 7, n.num desc",
+		"select Entity n select n.num order by n.num % 4 desc",
+		"select Entity n select n.num order by n.par.num % 5",
+		"select Entity n select n.num order by n.num / 10 desc, n.num % 3",
+		"select n.num % 6, count(n) from Entity n group by n.num % 6 order by count(n) desc",
+	} {
+		all := selectRows(t, db, q)
+		for _, limit := range []int{0, 1, 7, 49, 50, 60} {
+			for _, offset := range []int{0, 3, 45, 50} {
+				want := all[min(offset, len(all)):min(offset+limit, len(all))]
+				query := fmt.Sprintf("%s limit %d offset %d", q, limit, offset)
+				if got := selectRows(t, db, query); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %v, want %v", query, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package parser
+
+import "fmt"
+
+// Error is a failure to tokenize or parse, at the offending text.
+type Error struct {
+	Token *Token
+	Msg   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d", e.Msg, e.Token.Line, e.Token.Column)
+}
+
+// Span returns the first and last token of n and its descendants, or nil if it
+// has none.
+func (n *Node) Span() (first, last *Token) {
+	n.Visit(func(node *Node) {
+		for _, tok := range []*Token{node.token, node.op} {
+			if tok == nil {
+				continue
+			}
+			if first == nil || tok.Start < first.Start {
+				first = tok
+			}
+			if last == nil || tok.End > last.End {
+				last = tok
+			}
+		}
+	})
+	return first, last
+}
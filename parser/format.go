@@ -0,0 +1,484 @@
+package parser
+
+import (
+	"math"
+	"strings"
+)
+
+// Format prints n back to canonical query text: operands and keywords separated
+// by single spaces, parentheses only where precedence needs them, strings in
+// single quotes, and the declarations of a module, the members of a class and
+// the clauses of a query on lines of their own.
+func Format(n *Node) string {
+	f := &formatter{}
+	f.node(n)
+	return f.String()
+}
+
+// FormatSource formats the query text src. Comments at the end of the line of a
+// body or query clause stay there, and the others are put on lines of their own:
+// before the declaration or class member they precede, or before the body or
+// query clause that contains or follows them.
+func FormatSource(src string) (string, error) {
+	tokens, comments, err := tokenize(src)
+	if err != nil {
+		return "", err
+	}
+	module, err := ParseQuery(tokens)
+	if err != nil {
+		return "", err
+	}
+	f := &formatter{comments: comments}
+	f.node(module)
+	f.comment(len(src))
+	return f.String() + "\n", nil
+}
+
+// The precedence levels of expressions, from loosest to tightest binding. An if
+// takes everything to its right as its else branch, so it binds loosest of all.
+const (
+	precIf = iota
+	precOr
+	precAnd
+	precNot
+	precCompare
+	precIn
+	precIsNull
+	precAdditive
+	precMultiplicative
+	precNegation
+	precPrimary
+)
+
+var binaryPrecs = map[string]int{
+	"or":      precOr,
+	"and":     precAnd,
+	"==":      precCompare,
+	"!=":      precCompare,
+	"=~":      precCompare,
+	"matches": precCompare,
+	">=":      precCompare,
+	">":       precCompare,
+	"<=":      precCompare,
+	"<":       precCompare,
+	"+":       precAdditive,
+	"-":       precAdditive,
+	"*":       precMultiplicative,
+	"/":       precMultiplicative,
+	"%":       precMultiplicative,
+}
+
+func precedence(n *Node) int {
+	switch n.type_ {
+	case NodeTypeBinary:
+		return binaryPrecs[n.op.Text]
+	case NodeTypeUnary:
+		if n.op.Text == "-" {
+			return precNegation
+		}
+		return precNot
+	case NodeTypeIn:
+		return precIn
+	case NodeTypeIsNull:
+		return precIsNull
+	case NodeTypeIf:
+		return precIf
+	case NodeTypeNumber:
+		if strings.HasPrefix(n.token.Text, "-") {
+			return precNegation
+		}
+	}
+	return precPrimary
+}
+
+type formatter struct {
+	strings.Builder
+	indent   int
+	comments []*Token // comments not yet printed
+}
+
+// newline starts a new line at the current indentation.
+func (f *formatter) newline() {
+	f.WriteByte('\n')
+	f.WriteString(strings.Repeat("  ", f.indent))
+}
+
+// comment prints the comments starting before offset, each on a line of its own.
+func (f *formatter) comment(offset int) {
+	for len(f.comments) > 0 && f.comments[0].Start < offset {
+		if f.Len() > 0 {
+			f.newline()
+		}
+		f.WriteString(f.comments[0].Text)
+		f.comments = f.comments[1:]
+	}
+}
+
+// trailing prints the comments starting before offset on the line where n ends
+// at the end of the current line.
+func (f *formatter) trailing(n *Node, offset int) {
+	_, last := n.Span()
+	for last != nil && len(f.comments) > 0 && f.comments[0].Line == last.Line && f.comments[0].Start < offset {
+		f.WriteByte(' ')
+		f.WriteString(f.comments[0].Text)
+		f.comments = f.comments[1:]
+	}
+}
+
+// line starts a new line after the comments starting before offset, unless
+// nothing has been printed yet.
+func (f *formatter) line(offset int) {
+	f.comment(offset)
+	if f.Len() > 0 {
+		f.newline()
+	}
+}
+
+// start and end return the offsets of the text of n, or -1 if it has none.
+func start(n *Node) int {
+	if first, _ := n.Span(); first != nil {
+		return first.Start
+	}
+	return -1
+}
+
+func end(n *Node) int {
+	if _, last := n.Span(); last != nil {
+		return last.End
+	}
+	return -1
+}
+
+func (f *formatter) node(n *Node) {
+	switch n.type_ {
+	case NodeTypeModule:
+		f.module(n)
+	case NodeTypePredicate:
+		f.predicate(n)
+	case NodeTypeClass:
+		f.class(n)
+	case NodeTypeMember:
+		f.member(n)
+	case NodeTypeQuery:
+		f.query(n, false)
+	case NodeTypeFrom, NodeTypeWhere, NodeTypeSelect, NodeTypeGroupBy, NodeTypeOrderBy, NodeTypeLimit, NodeTypeOffset:
+		f.clause(n)
+	case NodeTypeVarDecl:
+		f.varDecl(n)
+	case NodeTypeOrderItem:
+		f.orderItem(n)
+	case NodeTypeWhen:
+		f.when(n)
+	default:
+		f.expr(n, precIf)
+	}
+}
+
+func (f *formatter) module(n *Node) {
+	for i, decl := range n.s {
+		if i > 0 {
+			f.WriteByte('\n')
+		}
+		f.line(start(decl))
+		f.node(decl)
+	}
+	if len(n.s) > 0 {
+		f.WriteByte('\n')
+	}
+	f.query(n.x, false)
+}
+
+func (f *formatter) predicate(n *Node) {
+	f.WriteString("predicate ")
+	f.WriteString(n.token.Text)
+	f.WriteByte('(')
+	f.varDecls(n.s)
+	f.WriteString(") {")
+	f.body(n.x)
+}
+
+func (f *formatter) class(n *Node) {
+	f.WriteString("class ")
+	f.WriteString(n.token.Text)
+	f.WriteString(" extends ")
+	f.WriteString(n.x.Ident())
+	f.WriteString(" {")
+	if len(n.s) == 0 {
+		f.WriteByte('}')
+		return
+	}
+	f.indent++
+	for _, member := range n.s {
+		f.line(start(member))
+		f.member(member)
+	}
+	f.indent--
+	f.newline()
+	f.WriteByte('}')
+}
+
+func (f *formatter) member(n *Node) {
+	f.WriteString(n.token.Text)
+	f.WriteString("() {")
+	f.body(n.x)
+}
+
+// body prints the indented body of a predicate or member and the closing brace.
+func (f *formatter) body(n *Node) {
+	f.indent++
+	f.line(end(n))
+	f.expr(n, precIf)
+	f.trailing(n, math.MaxInt)
+	f.indent--
+	f.newline()
+	f.WriteByte('}')
+}
+
+// query prints the clauses of n on lines of their own, or on one line if inline.
+func (f *formatter) query(n *Node, inline bool) {
+	for i, clause := range n.s {
+		if inline && i > 0 {
+			f.WriteByte(' ')
+		} else if !inline {
+			f.line(end(clause))
+		}
+		f.clause(clause)
+		if !inline {
+			next := math.MaxInt
+			if i+1 < len(n.s) {
+				next = start(n.s[i+1])
+			}
+			f.trailing(clause, next)
+		}
+	}
+}
+
+func (f *formatter) clause(n *Node) {
+	f.WriteString(n.op.Text)
+	switch n.type_ {
+	case NodeTypeGroupBy, NodeTypeOrderBy:
+		f.WriteString(" by")
+	}
+	f.WriteByte(' ')
+	switch n.type_ {
+	case NodeTypeFrom:
+		f.varDecls(n.s)
+	case NodeTypeSelect:
+		for i, item := range n.s {
+			if i > 0 {
+				f.WriteString(", ")
+			}
+			f.selectItem(item)
+		}
+	case NodeTypeOrderBy:
+		for i, item := range n.s {
+			if i > 0 {
+				f.WriteString(", ")
+			}
+			f.orderItem(item)
+		}
+	case NodeTypeGroupBy:
+		f.exprs(n.s)
+	default:
+		f.expr(n.x, precIf)
+	}
+}
+
+// selectItem prints an item of a select clause, keeping the parentheses around a
+// field, variable or aggregate, as they leave its column unnamed.
+func (f *formatter) selectItem(n *Node) {
+	if n.type_ == NodeTypeParen {
+		x := n.x
+		for x.type_ == NodeTypeParen {
+			x = x.x
+		}
+		switch x.type_ {
+		case NodeTypeSelector, NodeTypeIdent, NodeTypeAggregate:
+			f.WriteByte('(')
+			f.expr(x, precIf)
+			f.WriteByte(')')
+			return
+		}
+	}
+	f.expr(n, precIf)
+}
+
+func (f *formatter) orderItem(n *Node) {
+	f.expr(n.x, precIf)
+	if n.op != nil {
+		f.WriteByte(' ')
+		f.WriteString(n.op.Text)
+	}
+}
+
+func (f *formatter) varDecl(n *Node) {
+	f.WriteString(n.x.Ident())
+	f.WriteByte(' ')
+	f.WriteString(n.token.Text)
+}
+
+func (f *formatter) varDecls(decls []*Node) {
+	for i, decl := range decls {
+		if i > 0 {
+			f.WriteString(", ")
+		}
+		f.varDecl(decl)
+	}
+}
+
+func (f *formatter) exprs(items []*Node) {
+	for i, item := range items {
+		if i > 0 {
+			f.WriteString(", ")
+		}
+		f.expr(item, precIf)
+	}
+}
+
+func (f *formatter) when(n *Node) {
+	f.WriteString("when ")
+	f.expr(n.x, precIf)
+	f.WriteString(" then ")
+	f.expr(n.y, precIf)
+}
+
+// expr prints n where an expression of at least precedence prec is expected,
+// dropping its own parentheses and adding those it needs.
+func (f *formatter) expr(n *Node, prec int) {
+	for n.type_ == NodeTypeParen {
+		n = n.x
+	}
+	if precedence(n) < prec {
+		f.WriteByte('(')
+		f.expr(n, precIf)
+		f.WriteByte(')')
+		return
+	}
+	switch n.type_ {
+	case NodeTypeIdent, NodeTypeNumber, NodeTypeNull, NodeTypeBool, NodeTypeParam:
+		f.WriteString(n.token.Text)
+	case NodeTypeString:
+		if s, err := Unquote(n.token.Text); err == nil {
+			f.WriteString(Quote(s))
+		} else {
+			f.WriteString(n.token.Text)
+		}
+	case NodeTypeBinary:
+		p := precedence(n)
+		f.expr(n.x, p)
+		f.WriteByte(' ')
+		f.WriteString(n.op.Text)
+		f.WriteByte(' ')
+		f.expr(n.y, p+1)
+	case NodeTypeUnary:
+		f.WriteString(n.op.Text)
+		if n.op.Text == "not" {
+			f.WriteByte(' ')
+		}
+		f.expr(n.x, precedence(n))
+	case NodeTypeIsNull:
+		f.expr(n.x, precIsNull+1)
+		if n.token != nil {
+			f.WriteString(" is not null")
+		} else {
+			f.WriteString(" is null")
+		}
+	case NodeTypeIn:
+		f.expr(n.x, precIn+1)
+		if n.token != nil {
+			f.WriteString(" not")
+		}
+		f.WriteString(" in ")
+		f.set(n.y)
+	case NodeTypeList:
+		f.set(n)
+	case NodeTypeCall:
+		f.expr(n.x, precPrimary)
+		if n.op != nil {
+			f.WriteString(n.op.Text)
+		}
+		f.WriteByte('(')
+		f.exprs(n.s)
+		f.WriteByte(')')
+	case NodeTypeSelector:
+		x := n.x
+		for x != nil && x.type_ == NodeTypeParen {
+			x = x.x
+		}
+		if x != nil && x.type_ == NodeTypeNumber {
+			// the dot would be read as part of the number
+			f.WriteByte('(')
+			f.expr(x, precIf)
+			f.WriteByte(')')
+		} else if x != nil {
+			f.expr(x, precPrimary)
+		}
+		f.WriteByte('.')
+		f.WriteString(n.token.Text)
+	case NodeTypeIf:
+		f.WriteString("if ")
+		f.expr(n.x, precIf)
+		f.WriteString(" then ")
+		f.expr(n.y, precIf)
+		f.WriteString(" else ")
+		f.expr(n.z, precIf)
+	case NodeTypeCase:
+		f.WriteString("case")
+		for _, when := range n.s {
+			f.WriteByte(' ')
+			f.when(when)
+		}
+		if n.x != nil {
+			f.WriteString(" else ")
+			f.expr(n.x, precIf)
+		}
+		f.WriteString(" end")
+	case NodeTypeExists, NodeTypeForall:
+		f.WriteString(n.op.Text)
+		f.WriteByte('(')
+		f.varDecls(n.s)
+		for _, cond := range []*Node{n.x, n.y} {
+			if cond != nil {
+				f.WriteString(" | ")
+				f.expr(cond, precIf)
+			}
+		}
+		f.WriteByte(')')
+	case NodeTypeAggregate:
+		f.aggregate(n)
+	case NodeTypeQuery:
+		f.set(n)
+	}
+}
+
+// set prints the right side of in: a parenthesized list or query.
+func (f *formatter) set(n *Node) {
+	f.WriteByte('(')
+	if n.type_ == NodeTypeQuery {
+		f.query(n, true)
+	} else {
+		f.exprs(n.s)
+	}
+	f.WriteByte(')')
+}
+
+func (f *formatter) aggregate(n *Node) {
+	f.WriteString(n.op.Text)
+	f.WriteByte('(')
+	if len(n.s) == 0 {
+		f.expr(n.y, precIf)
+	} else {
+		f.varDecls(n.s)
+		for _, part := range []*Node{n.x, n.y} {
+			if part != nil {
+				f.WriteString(" | ")
+				f.expr(part, precIf)
+			}
+		}
+	}
+	if n.z != nil {
+		f.WriteString(", ")
+		f.expr(n.z, precIf)
+	}
+	f.WriteByte(')')
+}
@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+var formatTests = []string{
+	"select Entity n select n.num, n.opt, n.opt is null, coalesce(n.opt, -1), n.opt + 1",
+	"select Entity n where not (n.opt > 4 and n.num > 7) or (n.num - 1) * 2 > 3 select n.num",
+	"select count(n), sum(n.opt), avg(n.opt), concat(n.opt, ',') from Entity n group by n.opt is null order by count(n) desc, n.opt limit 3 offset 1",
+	"select Entity n where n.num in (2.0, 5) and n.num not in (select Entity m where m.num > 6 select m.parent) select n.num",
+	"select Entity n select if n.num < 3 then 'low' else if n.num < 7 then 'mid' else 'high', (n.num), (if true then 1 else 2) + 1",
+	"select Entity n select case when n.opt is null then 0 else n.opt * 10 end, case when n.num < 3 then \"it's\" end",
+	"select Entity n where exists(Entity m | m.parent == n.num) and forall(Entity m | m.num > 1 | m.num != n.num) select n.num",
+	"select Entity n where n.num == 1 select \"a\\tb\", 0x1F, -5, - -5, -n.num, true, null, 1.5e2 + 1, $f * 2",
+	"from Entity a, Entity b where a.parent == b.num select a, b",
+	"class Big extends Entity { Big() { this.num > 6 } twice() { this.num * 2 } } select Big b select b.twice",
+	"predicate anc(Entity a, Entity b) { a.parent == b or exists(Entity c | anc(a, c) and anc(c, b)) } select Entity a, Entity b where anc(a, b) select b.num",
+	"select max(Entity m | m.num < 5 | m.num), count(Entity m | m.num < 3) from Entity n where n.name.substr(1).length() == 1 and n.name matches 'e.'",
+	"select Entity n where n.num > 1 == true select n.num - (1 - 2), n.num / (2 * 3)",
+	"select Entity n select (1).foo, ((2.5)).bar(), (-1).baz, ('x').length()",
+}
+
+// shape prints n like Dump, but without parentheses and with string literals by
+// value, as formatting may change both without changing the query.
+func shape(n *Node) string {
+	for n.type_ == NodeTypeParen {
+		n = n.x
+	}
+	var sb strings.Builder
+	sb.WriteString(n.type_)
+	if n.token != nil {
+		text := n.token.Text
+		if n.type_ == NodeTypeString {
+			text, _ = Unquote(text)
+		}
+		sb.WriteString(" " + text)
+	}
+	if n.op != nil {
+		sb.WriteString(" " + n.op.Text)
+	}
+	for _, t := range []*Node{n.x, n.y, n.z} {
+		if t != nil {
+			sb.WriteString(" (" + shape(t) + ")")
+		}
+	}
+	for _, t := range n.s {
+		sb.WriteString(" [" + shape(t) + "]")
+	}
+	return sb.String()
+}
+
+func parse(t *testing.T, src string) *Node {
+	t.Helper()
+	tokens, err := Tokenize(src)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	node, err := ParseQuery(tokens)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return node
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, src := range formatTests {
+		node := parse(t, src)
+		out := Format(node)
+		if got, want := shape(parse(t, out)), shape(node); got != want {
+			t.Errorf("%s: formatted as %s, which parses to\n%s\nwant\n%s", src, out, got, want)
+		}
+		if again := Format(parse(t, out)); again != out {
+			t.Errorf("%s: formatted as %s, then as %s", src, out, again)
+		}
+	}
+}
+
+func TestFormatSourceIdempotent(t *testing.T) {
+	for _, src := range append(formatTests, "// anc\npredicate p(Entity a) {\n  a.num > 1 // big\n}\n/* query */ select Entity n where p(n)") {
+		out, err := FormatSource(src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if again, err := FormatSource(out); err != nil || again != out {
+			t.Errorf("%s: formatted as\n%s\nthen as\n%s (%v)", src, out, again, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+type tokenJSON struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// MarshalJSON encodes t as an object with its type, text and span.
+func (t *Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tokenJSON(*t))
+}
+
+// UnmarshalJSON decodes a token encoded by MarshalJSON, checking that it has a
+// type and a valid span.
+func (t *Token) UnmarshalJSON(data []byte) error {
+	var v tokenJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Type == "" {
+		return fmt.Errorf("token %q without type", v.Text)
+	} else if v.Start < 0 || v.End < v.Start {
+		return fmt.Errorf("token %q with invalid span %d-%d", v.Text, v.Start, v.End)
+	}
+	*t = Token(v)
+	return nil
+}
+
+// nodeJSON has the slots of a Node under the names Dump gives them.
+type nodeJSON struct {
+	Type  string  `json:"type"`
+	Token *Token  `json:"token,omitempty"`
+	Op    *Token  `json:"op,omitempty"`
+	X     *Node   `json:"x,omitempty"`
+	Y     *Node   `json:"y,omitempty"`
+	Z     *Node   `json:"z,omitempty"`
+	S     []*Node `json:"s,omitempty"`
+}
+
+// requiredSlots lists for each type of node the slots it cannot do without.
+var requiredSlots = map[string][]string{
+	NodeTypeIdent:     {"token"},
+	NodeTypeNumber:    {"token"},
+	NodeTypeString:    {"token"},
+	NodeTypeNull:      {"token"},
+	NodeTypeBool:      {"token"},
+	NodeTypeParam:     {"token"},
+	NodeTypeUnary:     {"op", "x"},
+	NodeTypeBinary:    {"op", "x", "y"},
+	NodeTypeCall:      {"x"},
+	NodeTypeSelector:  {"token"},
+	NodeTypeParen:     {"x"},
+	NodeTypeIsNull:    {"op", "x"},
+	NodeTypeIn:        {"op", "x", "y"},
+	NodeTypeList:      {},
+	NodeTypeIf:        {"op", "x", "y", "z"},
+	NodeTypeCase:      {"op", "s"},
+	NodeTypeWhen:      {"op", "x", "y"},
+	NodeTypeVarDecl:   {"token", "x"},
+	NodeTypeQuery:     {"s"},
+	NodeTypeFrom:      {"op", "s"},
+	NodeTypeWhere:     {"op", "x"},
+	NodeTypeSelect:    {"op", "s"},
+	NodeTypeExists:    {"op", "s"},
+	NodeTypeForall:    {"op", "s", "y"},
+	NodeTypePredicate: {"op", "token", "x"},
+	NodeTypeModule:    {"x"},
+	NodeTypeClass:     {"op", "token", "x"},
+	NodeTypeMember:    {"token", "x"},
+	NodeTypeAggregate: {"op"},
+	NodeTypeGroupBy:   {"op", "s"},
+	NodeTypeOrderBy:   {"op", "s"},
+	NodeTypeOrderItem: {"x"},
+	NodeTypeLimit:     {"op", "x"},
+	NodeTypeOffset:    {"op", "x"},
+}
+
+// childTypes lists for some types of node the types of node allowed in some of
+// their slots, where code reading the tree relies on them.
+var childTypes = map[string]map[string][]string{
+	NodeTypeIn:        {"y": {NodeTypeQuery, NodeTypeList}},
+	NodeTypeCase:      {"s": {NodeTypeWhen}},
+	NodeTypeVarDecl:   {"x": {NodeTypeIdent}},
+	NodeTypeQuery:     {"s": {NodeTypeFrom, NodeTypeWhere, NodeTypeSelect, NodeTypeGroupBy, NodeTypeOrderBy, NodeTypeLimit, NodeTypeOffset}},
+	NodeTypeFrom:      {"s": {NodeTypeVarDecl}},
+	NodeTypeExists:    {"s": {NodeTypeVarDecl}},
+	NodeTypeForall:    {"s": {NodeTypeVarDecl}},
+	NodeTypePredicate: {"s": {NodeTypeVarDecl}},
+	NodeTypeModule:    {"x": {NodeTypeQuery}, "s": {NodeTypePredicate, NodeTypeClass}},
+	NodeTypeClass:     {"x": {NodeTypeIdent}, "s": {NodeTypeMember}},
+	NodeTypeAggregate: {"s": {NodeTypeVarDecl}},
+	NodeTypeOrderBy:   {"s": {NodeTypeOrderItem}},
+}
+
+// opTexts lists for the types of node whose op is an operator or a name the
+// texts it may have.
+var opTexts = map[string][]string{
+	NodeTypeUnary:     {"-", "!", "not"},
+	NodeTypeBinary:    {"or", "and", "==", "!=", "=~", "matches", ">=", ">", "<=", "<", "+", "-", "*", "/", "%"},
+	NodeTypeCall:      {"+", "*"},
+	NodeTypeOrderItem: {"asc", "desc"},
+	NodeTypeAggregate: {"count", "sum", "min", "max", "avg", "concat"},
+}
+
+// isIdent reports whether s is an identifier as the tokenizer scans them.
+func isIdent(s string) bool {
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// checkChildren checks that the children of a node of type type_ in the slots
+// childTypes restricts are of an allowed type.
+func checkChildren(type_ string, v *nodeJSON) error {
+	for _, slot := range []string{"x", "y", "s"} {
+		types, ok := childTypes[type_][slot]
+		if !ok {
+			continue
+		}
+		var children []*Node
+		switch slot {
+		case "x":
+			children = []*Node{v.X}
+		case "y":
+			children = []*Node{v.Y}
+		case "s":
+			children = v.S
+		}
+		for _, child := range children {
+			if child != nil && !slices.Contains(types, child.type_) {
+				return fmt.Errorf("%s node with %s in %s", type_, child.type_, slot)
+			}
+		}
+	}
+	return nil
+}
+
+// MarshalJSON encodes n as an object with its type and the slots it uses,
+// tokens included with their spans.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(nodeJSON{Type: n.type_, Token: n.token, Op: n.op, X: n.x, Y: n.y, Z: n.z, S: n.s})
+}
+
+// UnmarshalJSON decodes a node encoded by MarshalJSON, checking that its type is
+// known, that it has the slots the type needs and that its children are of the
+// types they must be, so that the tree is one the parser could have built.
+func (n *Node) UnmarshalJSON(data []byte) error {
+	var v nodeJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	slots, ok := requiredSlots[v.Type]
+	if !ok {
+		return fmt.Errorf("unknown node type %q", v.Type)
+	}
+	for _, slot := range slots {
+		missing := false
+		switch slot {
+		case "token":
+			missing = v.Token == nil
+		case "op":
+			missing = v.Op == nil
+		case "x":
+			missing = v.X == nil
+		case "y":
+			missing = v.Y == nil
+		case "z":
+			missing = v.Z == nil
+		case "s":
+			missing = len(v.S) == 0
+		}
+		if missing {
+			return fmt.Errorf("%s node without %s", v.Type, slot)
+		}
+	}
+	for _, item := range v.S {
+		if item == nil {
+			return fmt.Errorf("%s node with null in s", v.Type)
+		}
+	}
+	if texts, ok := opTexts[v.Type]; ok && v.Op != nil && !slices.Contains(texts, v.Op.Text) {
+		return fmt.Errorf("%s node with invalid op %q", v.Type, v.Op.Text)
+	}
+	if v.Type == NodeTypeParam && (!strings.HasPrefix(v.Token.Text, "$") || !isIdent(v.Token.Text[1:])) {
+		return fmt.Errorf("%s node with invalid token %q", v.Type, v.Token.Text)
+	}
+	if v.Type == NodeTypeAggregate && len(v.S) == 0 && v.Y == nil {
+		return fmt.Errorf("%s node without s or y", v.Type)
+	}
+	if err := checkChildren(v.Type, &v); err != nil {
+		return err
+	}
+	*n = Node{type_: v.Type, token: v.Token, op: v.Op, x: v.X, y: v.Y, z: v.Z, s: v.S}
+	return nil
+}
@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNodeJSON(t *testing.T) {
+	for _, src := range formatTests {
+		node := parse(t, src)
+		data, err := json.Marshal(node)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		var decoded Node
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if got, want := decoded.Dump(), node.Dump(); got != want {
+			t.Errorf("%s: decoded as\n%s\nwant\n%s", src, got, want)
+		}
+	}
+}
+
+func TestNodeJSONInvalid(t *testing.T) {
+	tok := func(text string) string {
+		return `{"type":"x","text":"` + text + `","start":0,"end":0,"line":1,"column":1}`
+	}
+	ident := `{"type":"ident","token":` + tok("n") + `}`
+	decl := `{"type":"var_decl","token":` + tok("n") + `,"x":` + ident + `}`
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"type":"nope"}`, "unknown node type"},
+		{`{"type":"binary","op":` + tok("+") + `,"x":` + ident + `}`, "binary node without y"},
+		{`{"type":"list","s":[null]}`, "list node with null in s"},
+		{`{"type":"var_decl","token":` + tok("n") + `,"x":{"type":"number","token":` + tok("1") + `}}`, "var_decl node with number in x"},
+		{`{"type":"from","op":` + tok("from") + `,"s":[` + ident + `]}`, "from node with ident in s"},
+		{`{"type":"exists","op":` + tok("exists") + `,"s":[` + ident + `]}`, "exists node with ident in s"},
+		{`{"type":"case","op":` + tok("case") + `,"s":[` + ident + `]}`, "case node with ident in s"},
+		{`{"type":"order_by","op":` + tok("order") + `,"s":[` + ident + `]}`, "order_by node with ident in s"},
+		{`{"type":"in","op":` + tok("in") + `,"x":` + ident + `,"y":` + ident + `}`, "in node with ident in y"},
+		{`{"type":"aggregate","op":` + tok("count") + `}`, "aggregate node without s or y"},
+		{`{"type":"query","s":[` + decl + `]}`, "query node with var_decl in s"},
+		{`{"type":"module","x":` + ident + `}`, "module node with ident in x"},
+		{`{"type":"unary","op":` + tok("foo") + `,"x":` + ident + `}`, `unary node with invalid op "foo"`},
+		{`{"type":"binary","op":` + tok("^") + `,"x":` + ident + `,"y":` + ident + `}`, `binary node with invalid op "^"`},
+		{`{"type":"call","op":` + tok("?") + `,"x":` + ident + `}`, `call node with invalid op "?"`},
+		{`{"type":"order_item","op":` + tok("up") + `,"x":` + ident + `}`, `order_item node with invalid op "up"`},
+		{`{"type":"aggregate","op":` + tok("median") + `,"y":` + ident + `}`, `aggregate node with invalid op "median"`},
+		{`{"type":"param","token":` + tok("") + `}`, `param node with invalid token ""`},
+		{`{"type":"param","token":` + tok("$") + `}`, `param node with invalid token "$"`},
+		{`{"type":"param","token":` + tok("$1x") + `}`, `param node with invalid token "$1x"`},
+	}
+	for _, test := range tests {
+		var n Node
+		if err := json.Unmarshal([]byte(test.data), &n); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %s", test.data, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Unquote returns the value of a string literal: '...' or "..." with Go escape
+// sequences, or `...` taken verbatim.
+func Unquote(text string) (string, error) {
+	if len(text) < 2 || text[0] != text[len(text)-1] {
+		return "", fmt.Errorf("invalid string %s", text)
+	}
+	quote, body := text[0], text[1:len(text)-1]
+	if quote == '`' {
+		if strings.IndexByte(body, '`') >= 0 {
+			return "", fmt.Errorf("invalid string %s", text)
+		}
+		return body, nil
+	} else if quote != '\'' && quote != '"' {
+		return "", fmt.Errorf("invalid string %s", text)
+	}
+	var sb strings.Builder
+	for body != "" {
+		r, multibyte, tail, err := strconv.UnquoteChar(body, quote)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in string %s", text)
+		}
+		if r < utf8.RuneSelf || !multibyte {
+			sb.WriteByte(byte(r))
+		} else {
+			sb.WriteRune(r)
+		}
+		body = tail
+	}
+	return sb.String(), nil
+}
+
+// IsFloat reports whether the number literal text has a fraction or exponent.
+func IsFloat(text string) bool {
+	return !hasBasePrefix(strings.TrimPrefix(text, "-")) && strings.ContainsAny(text, ".eE")
+}
+
+// ParseInt returns the value of an integer literal: decimal, or hexadecimal,
+// octal or binary with a 0x, 0o or 0b prefix, with _ between digits and an
+// optional minus sign. Unlike in Go, a leading 0 does not mean octal.
+func ParseInt(text string) (int, error) {
+	sign, digits := "", text
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if !hasBasePrefix(digits) && strings.HasPrefix(digits, "0") {
+		if digits = strings.TrimLeft(digits, "0"); digits == "" {
+			digits = "0"
+		}
+	}
+	i, err := strconv.ParseInt(sign+digits, 0, 64)
+	if err != nil || strings.HasPrefix(digits, "_") {
+		return 0, fmt.Errorf("invalid number %s", text)
+	}
+	return int(i), nil
+}
+
+func ParseFloat(text string) (float64, error) {
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %s", text)
+	}
+	return f, nil
+}
+
+func hasBasePrefix(text string) bool {
+	return len(text) > 1 && text[0] == '0' && strings.ContainsRune("xXoObB", rune(text[1]))
+}
+
+// Quote returns a '...' string literal with value s, using Go escape sequences
+// for quotes, backslashes and characters that are not printable.
+func Quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('\'')
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && size == 1 {
+			sb.WriteString(fmt.Sprintf(`\x%02x`, s[0]))
+		} else if r == '\'' {
+			sb.WriteString(`\'`)
+		} else if r == '"' {
+			sb.WriteByte('"')
+		} else {
+			q := strconv.Quote(string(r))
+			sb.WriteString(q[1 : len(q)-1])
+		}
+		s = s[size:]
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+		err  string
+	}{
+		{`'abc'`, "abc", ""},
+		{`''`, "", ""},
+		{`"it's"`, "it's", ""},
+		{`'say "hi"'`, `say "hi"`, ""},
+		{`'\'"\\'`, `'"\`, ""},
+		{`"\"'"`, `"'`, ""},
+		{`'a\tb\nc'`, "a\tb\nc", ""},
+		{`'\x41é\U0001F600'`, "Aé😀", ""},
+		{`'\xff'`, "\xff", ""},
+		{`'héllo'`, "héllo", ""},
+		{"`a\\nb'\"`", `a\nb'"`, ""},
+		{"`\n`", "\n", ""},
+		{`'\q'`, "", `invalid escape in string '\q'`},
+		// as in Go, only the enclosing quote may be escaped
+		{`'\"'`, "", `invalid escape in string '\"'`},
+		{`'\u00'`, "", `invalid escape in string '\u00'`},
+		{`'abc"`, "", `invalid string 'abc"`},
+		{`'`, "", `invalid string '`},
+		{`abc`, "", `invalid string abc`},
+		{"`a`b`", "", "invalid string `a`b`"},
+	}
+	for _, test := range tests {
+		got, err := Unquote(test.text)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("Unquote(%s): got error %v, want %s", test.text, err, test.err)
+			}
+		} else if err != nil {
+			t.Errorf("Unquote(%s): %v", test.text, err)
+		} else if got != test.want {
+			t.Errorf("Unquote(%s) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestParseInt(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"42", 42, true},
+		{"-42", -42, true},
+		// a leading 0 does not mean octal
+		{"010", 10, true},
+		{"00", 0, true},
+		{"0x1F", 31, true},
+		{"0XfF", 255, true},
+		{"0o17", 15, true},
+		{"0b101", 5, true},
+		{"-0x10", -16, true},
+		{"0x_ff", 255, true},
+		{"1_000", 1000, true},
+		{"1_000_000", 1000000, true},
+		{"9223372036854775807", 9223372036854775807, true},
+		{"-9223372036854775808", -9223372036854775808, true},
+		{"9223372036854775808", 0, false},
+		{"-9223372036854775809", 0, false},
+		{"1__0", 0, false},
+		{"_1", 0, false},
+		{"1_", 0, false},
+		{"0_1", 0, false},
+		{"0x", 0, false},
+		{"0b2", 0, false},
+		{"0o8", 0, false},
+		{"12ab", 0, false},
+		{"", 0, false},
+		{"-", 0, false},
+	}
+	for _, test := range tests {
+		got, err := ParseInt(test.text)
+		if !test.ok {
+			if err == nil || err.Error() != "invalid number "+test.text {
+				t.Errorf("ParseInt(%q): got %d, %v, want an invalid number", test.text, got, err)
+			}
+		} else if err != nil || got != test.want {
+			t.Errorf("ParseInt(%q) = %d, %v, want %d", test.text, got, err, test.want)
+		}
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		err  string
+	}{
+		{"-5", "number -5", ""},
+		{"-9223372036854775808", "number -9223372036854775808", ""},
+		{"-0x8000000000000000", "number -0x8000000000000000", ""},
+		{"-1.5e2", "number -1.5e2", ""},
+		// only a minus sign directly before the number is part of it
+		{"- 5", "unary - (number 5)", ""},
+		{"- -5", "unary - (number -5)", ""},
+		{"--9223372036854775808", "unary - (number -9223372036854775808)", ""},
+		{"1 -5", "binary - (number 1) (number 5)", ""},
+		{"9223372036854775808", "", "invalid number 9223372036854775808 at line 1, column 1"},
+		{"- 9223372036854775808", "", "invalid number 9223372036854775808 at line 1, column 3"},
+		{"1 -9223372036854775808", "", "invalid number 9223372036854775808 at line 1, column 4"},
+		{"(-9223372036854775808) + 9223372036854775808", "", "invalid number 9223372036854775808 at line 1, column 26"},
+	}
+	for _, test := range tests {
+		tokens, err := Tokenize(test.src)
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+			continue
+		}
+		node, err := Parse(tokens)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, want %s", test.src, err, test.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if got := shape(node); got != test.want {
+			t.Errorf("%s = %s, want %s", test.src, got, test.want)
+		}
+	}
+}
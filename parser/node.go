@@ -13,6 +13,32 @@ const NodeTypeBinary = "binary"
 const NodeTypeCall = "call"
 const NodeTypeSelector = "selector"
 const NodeTypeParen = "paren"
+const NodeTypeNull = "null"
+const NodeTypeBool = "bool"
+const NodeTypeParam = "param"
+const NodeTypeIsNull = "is_null"
+const NodeTypeIn = "in"
+const NodeTypeList = "list"
+const NodeTypeIf = "if"
+const NodeTypeCase = "case"
+const NodeTypeWhen = "when"
+const NodeTypeVarDecl = "var_decl"
+const NodeTypeQuery = "query"
+const NodeTypeFrom = "from"
+const NodeTypeWhere = "where"
+const NodeTypeSelect = "select"
+const NodeTypeExists = "exists"
+const NodeTypeForall = "forall"
+const NodeTypePredicate = "predicate"
+const NodeTypeModule = "module"
+const NodeTypeClass = "class"
+const NodeTypeMember = "member"
+const NodeTypeAggregate = "aggregate"
+const NodeTypeGroupBy = "group_by"
+const NodeTypeOrderBy = "order_by"
+const NodeTypeOrderItem = "order_item"
+const NodeTypeLimit = "limit"
+const NodeTypeOffset = "offset"
 
 func NewIdentNode(token *Token) *Node {
 	return &Node{type_: NodeTypeIdent, token: token}
@@ -26,6 +52,46 @@ func NewStringNode(token *Token) *Node {
 	return &Node{type_: NodeTypeString, token: token}
 }
 
+func NewNullNode(token *Token) *Node {
+	return &Node{type_: NodeTypeNull, token: token}
+}
+
+func NewBoolNode(token *Token) *Node {
+	return &Node{type_: NodeTypeBool, token: token}
+}
+
+func NewParamNode(token *Token) *Node {
+	return &Node{type_: NodeTypeParam, token: token}
+}
+
+// NewIsNullNode creates x is null, or x is not null if not is given.
+func NewIsNullNode(x *Node, is, not *Token) *Node {
+	return &Node{type_: NodeTypeIsNull, x: x, op: is, token: not}
+}
+
+// NewInNode creates x in set, or x not in set if not is given. The set is a list
+// or a query.
+func NewInNode(x *Node, in, not *Token, set *Node) *Node {
+	return &Node{type_: NodeTypeIn, x: x, op: in, token: not, y: set}
+}
+
+func NewListNode(items []*Node) *Node {
+	return &Node{type_: NodeTypeList, s: items}
+}
+
+func NewIfNode(keyword *Token, cond, then, else_ *Node) *Node {
+	return &Node{type_: NodeTypeIf, op: keyword, x: cond, y: then, z: else_}
+}
+
+// NewCaseNode creates case when ... then ... [else ...] end; else_ may be nil.
+func NewCaseNode(keyword *Token, whens []*Node, else_ *Node) *Node {
+	return &Node{type_: NodeTypeCase, op: keyword, s: whens, x: else_}
+}
+
+func NewWhenNode(keyword *Token, cond, result *Node) *Node {
+	return &Node{type_: NodeTypeWhen, op: keyword, x: cond, y: result}
+}
+
 func NewUnaryNode(op *Token, target *Node) *Node {
 	return &Node{type_: NodeTypeUnary, op: op, x: target}
 }
@@ -38,6 +104,12 @@ func NewCallNode(callee *Node, args []*Node) *Node {
 	return &Node{type_: NodeTypeCall, x: callee, s: args}
 }
 
+// NewClosureNode creates a call of the transitive (+) or reflexive transitive (*)
+// closure of a binary predicate.
+func NewClosureNode(callee *Node, op *Token, args []*Node) *Node {
+	return &Node{type_: NodeTypeCall, x: callee, op: op, s: args}
+}
+
 func NewSelectorNode(target *Node, key *Token) *Node {
 	return &Node{type_: NodeTypeSelector, x: target, token: key}
 }
@@ -46,13 +118,54 @@ func NewParenNode(n *Node) *Node {
 	return &Node{type_: NodeTypeParen, x: n}
 }
 
+func NewVarDeclNode(table, name *Token) *Node {
+	return &Node{type_: NodeTypeVarDecl, x: NewIdentNode(table), token: name}
+}
+
+func NewQuantifierNode(type_ string, keyword *Token, decls []*Node, guard, cond *Node) *Node {
+	return &Node{type_: type_, op: keyword, s: decls, x: guard, y: cond}
+}
+
+func NewPredicateNode(keyword, name *Token, params []*Node, body *Node) *Node {
+	return &Node{type_: NodeTypePredicate, op: keyword, token: name, s: params, x: body}
+}
+
+func NewClassNode(keyword, name, base *Token, members []*Node) *Node {
+	return &Node{type_: NodeTypeClass, op: keyword, token: name, x: NewIdentNode(base), s: members}
+}
+
+func NewMemberNode(name *Token, body *Node) *Node {
+	return &Node{type_: NodeTypeMember, token: name, x: body}
+}
+
+func NewModuleNode(decls []*Node, query *Node) *Node {
+	return &Node{type_: NodeTypeModule, s: decls, x: query}
+}
+
+func NewAggregateNode(name *Token, decls []*Node, cond, expr, sep *Node) *Node {
+	return &Node{type_: NodeTypeAggregate, op: name, s: decls, x: cond, y: expr, z: sep}
+}
+
+func NewOrderItemNode(expr *Node, direction *Token) *Node {
+	return &Node{type_: NodeTypeOrderItem, x: expr, op: direction}
+}
+
+func NewClauseNode(type_ string, keyword *Token, items []*Node, x *Node) *Node {
+	return &Node{type_: type_, op: keyword, s: items, x: x}
+}
+
+func NewQueryNode(clauses []*Node) *Node {
+	return &Node{type_: NodeTypeQuery, s: clauses}
+}
+
 type Node struct {
 	type_ string
-	token *Token  // ident, number, string
-	op    *Token  // unary, binary
-	x     *Node   // unary, binary lhs, call callee
-	y     *Node   // binary rhs
-	s     []*Node // call args
+	token *Token  // ident, number, string, null, bool, param, var decl name, predicate/class/member name, is not, not in
+	op    *Token  // unary, binary, is null, in, if/case/when keyword, closure call, clause keyword, quantifier keyword, predicate/class keyword, aggregate name, order direction
+	x     *Node   // unary, binary lhs, is null, in, if/when cond, case else, call callee, var decl table, where cond, limit/offset, quantifier guard, predicate/member body, class base, module query, aggregate cond, order expr
+	y     *Node   // binary rhs, in set, if then, when result, quantifier cond, aggregate expr
+	z     *Node   // aggregate separator, if else
+	s     []*Node // call args, list items, case whens, query clauses, from var decls, select items, group by keys, order items, quantifier/aggregate var decls, predicate params, class members, module decls
 }
 
 func (n *Node) Type() string {
@@ -79,6 +192,14 @@ func (n *Node) Args() []*Node {
 	return n.s
 }
 
+// Closure returns "+" or "*" for a closure call, or "" for a plain call.
+func (n *Node) Closure() string {
+	if n.op == nil {
+		return ""
+	}
+	return n.op.Text
+}
+
 func (n *Node) SelectorTarget() *Node {
 	return n.x
 }
@@ -91,6 +212,224 @@ func (n *Node) ParenTarget() *Node {
 	return n.x
 }
 
+func (n *Node) IsNullTarget() *Node {
+	return n.x
+}
+
+// IsNullNegated reports whether n is an is not null test.
+func (n *Node) IsNullNegated() bool {
+	return n.token != nil
+}
+
+func (n *Node) InTarget() *Node {
+	return n.x
+}
+
+// InSet returns the list or query node on the right of in.
+func (n *Node) InSet() *Node {
+	return n.y
+}
+
+// InNegated reports whether n is a not in test.
+func (n *Node) InNegated() bool {
+	return n.token != nil
+}
+
+func (n *Node) ListItems() []*Node {
+	return n.s
+}
+
+func (n *Node) IfCond() *Node {
+	return n.x
+}
+
+func (n *Node) IfThen() *Node {
+	return n.y
+}
+
+func (n *Node) IfElse() *Node {
+	return n.z
+}
+
+func (n *Node) CaseWhens() []*Node {
+	return n.s
+}
+
+// CaseElse returns the else result of a case, or nil if it has none.
+func (n *Node) CaseElse() *Node {
+	return n.x
+}
+
+func (n *Node) WhenCond() *Node {
+	return n.x
+}
+
+func (n *Node) WhenResult() *Node {
+	return n.y
+}
+
+func (n *Node) VarDeclTable() string {
+	return n.x.Ident()
+}
+
+func (n *Node) VarDeclName() string {
+	return n.token.Text
+}
+
+func (n *Node) Keyword() string {
+	return n.op.Text
+}
+
+func (n *Node) Clauses() []*Node {
+	return n.s
+}
+
+func (n *Node) ClauseItems() []*Node {
+	return n.s
+}
+
+func (n *Node) ClauseExpr() *Node {
+	return n.x
+}
+
+func (n *Node) QueryClause(type_ string) *Node {
+	for _, c := range n.s {
+		if c.type_ == type_ {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *Node) QueryFrom() []*Node {
+	if c := n.QueryClause(NodeTypeFrom); c != nil {
+		return c.s
+	}
+	return nil
+}
+
+func (n *Node) QueryWhere() *Node {
+	if c := n.QueryClause(NodeTypeWhere); c != nil {
+		return c.x
+	}
+	return nil
+}
+
+func (n *Node) QuerySelect() []*Node {
+	if c := n.QueryClause(NodeTypeSelect); c != nil {
+		return c.s
+	}
+	return nil
+}
+
+func (n *Node) QueryGroupBy() []*Node {
+	if c := n.QueryClause(NodeTypeGroupBy); c != nil {
+		return c.s
+	}
+	return nil
+}
+
+func (n *Node) QueryOrderBy() []*Node {
+	if c := n.QueryClause(NodeTypeOrderBy); c != nil {
+		return c.s
+	}
+	return nil
+}
+
+func (n *Node) QueryLimit() *Node {
+	if c := n.QueryClause(NodeTypeLimit); c != nil {
+		return c.x
+	}
+	return nil
+}
+
+func (n *Node) QueryOffset() *Node {
+	if c := n.QueryClause(NodeTypeOffset); c != nil {
+		return c.x
+	}
+	return nil
+}
+
+func (n *Node) OrderItemExpr() *Node {
+	return n.x
+}
+
+func (n *Node) OrderItemDesc() bool {
+	return n.op != nil && n.op.Text == "desc"
+}
+
+func (n *Node) QuantifierDecls() []*Node {
+	return n.s
+}
+
+func (n *Node) QuantifierGuard() *Node {
+	return n.x
+}
+
+func (n *Node) QuantifierCond() *Node {
+	return n.y
+}
+
+func (n *Node) PredicateName() string {
+	return n.token.Text
+}
+
+func (n *Node) PredicateParams() []*Node {
+	return n.s
+}
+
+func (n *Node) PredicateBody() *Node {
+	return n.x
+}
+
+func (n *Node) AggregateName() string {
+	return n.op.Text
+}
+
+func (n *Node) AggregateDecls() []*Node {
+	return n.s
+}
+
+func (n *Node) AggregateCond() *Node {
+	return n.x
+}
+
+func (n *Node) AggregateExpr() *Node {
+	return n.y
+}
+
+func (n *Node) AggregateSeparator() *Node {
+	return n.z
+}
+
+func (n *Node) ClassName() string {
+	return n.token.Text
+}
+
+func (n *Node) ClassBase() string {
+	return n.x.Ident()
+}
+
+func (n *Node) ClassMembers() []*Node {
+	return n.s
+}
+
+func (n *Node) MemberName() string {
+	return n.token.Text
+}
+
+func (n *Node) MemberBody() *Node {
+	return n.x
+}
+
+func (n *Node) ModuleDecls() []*Node {
+	return n.s
+}
+
+func (n *Node) ModuleQuery() *Node {
+	return n.x
+}
+
 func (n *Node) Ident() string {
 	return n.token.Text
 }
@@ -99,6 +438,15 @@ func (n *Node) Number() string {
 	return n.token.Text
 }
 
+// Param returns the name of a bind parameter without the leading $.
+func (n *Node) Param() string {
+	return n.token.Text[1:]
+}
+
+func (n *Node) Bool() bool {
+	return n.token.Text == "true"
+}
+
 func (n *Node) String() string {
 	if n.token == nil {
 		return ""
@@ -110,6 +458,17 @@ func (n *Node) Op() string {
 	return n.op.Text
 }
 
+// Children returns the nodes directly below n, in the order Visit visits them.
+func (n *Node) Children() []*Node {
+	var children []*Node
+	for _, t := range []*Node{n.x, n.y, n.z} {
+		if t != nil {
+			children = append(children, t)
+		}
+	}
+	return append(children, n.s...)
+}
+
 func (n *Node) Visit(f func(node *Node)) {
 	f(n)
 	if n.x != nil {
@@ -118,6 +477,9 @@ func (n *Node) Visit(f func(node *Node)) {
 	if n.y != nil {
 		n.y.Visit(f)
 	}
+	if n.z != nil {
+		n.z.Visit(f)
+	}
 	for _, t := range n.s {
 		t.Visit(f)
 	}
@@ -138,6 +500,9 @@ func (n *Node) Dump() string {
 	if n.y != nil {
 		sb.WriteString(fmt.Sprintf("y=(%s) ", n.y.Dump()))
 	}
+	if n.z != nil {
+		sb.WriteString(fmt.Sprintf("z=(%s) ", n.z.Dump()))
+	}
 	if len(n.s) > 0 {
 		sb.WriteString("s=[")
 		for _, t := range n.s {
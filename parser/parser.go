@@ -3,6 +3,7 @@ package parser
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 func Parse(tokens []*Token) (*Node, error) {
@@ -16,12 +17,98 @@ func Parse(tokens []*Token) (*Node, error) {
 	}
 	ret := ps.expr()
 	if ret == nil || ps.la.Type != TokenTypeEndOfFile {
-		tok := tokens[ps.max_]
-		return nil, fmt.Errorf("fail to parse: \"%s\" at %d", tok.Text, tok.Start)
+		return nil, unexpected(tokens[ps.max_])
+	}
+	if err := checkNumbers(ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func ParseQuery(tokens []*Token) (*Node, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("empty tokens")
+	}
+	ps := Parser{
+		tokens: tokens,
+		pos:    0,
+		la:     tokens[0],
+	}
+	ret := ps.module()
+	if ret == nil || ps.la.Type != TokenTypeEndOfFile {
+		return nil, unexpected(tokens[ps.max_])
+	}
+	if err := checkNumbers(ret); err != nil {
+		return nil, err
 	}
 	return ret, nil
 }
 
+// checkNumbers returns an error for the first integer literal in node that is
+// out of range. The tokenizer only checks the magnitude, as a minus sign
+// directly before a number is merged into it by the parser.
+func checkNumbers(node *Node) error {
+	var err error
+	node.Visit(func(n *Node) {
+		if err == nil && n.Type() == NodeTypeNumber && !IsFloat(n.Number()) {
+			if _, e := ParseInt(n.Number()); e != nil {
+				err = &Error{Token: n.token, Msg: e.Error()}
+			}
+		}
+	})
+	return err
+}
+
+func unexpected(tok *Token) *Error {
+	if tok.Type == TokenTypeEndOfFile {
+		return &Error{Token: tok, Msg: "unexpected end of text"}
+	}
+	return &Error{Token: tok, Msg: fmt.Sprintf("unexpected %s", tok.Text)}
+}
+
+var keywords = map[string]bool{
+	"select":    true,
+	"where":     true,
+	"from":      true,
+	"exists":    true,
+	"forall":    true,
+	"predicate": true,
+	"class":     true,
+	"extends":   true,
+	"count":     true,
+	"sum":       true,
+	"min":       true,
+	"max":       true,
+	"avg":       true,
+	"concat":    true,
+	"group":     true,
+	"order":     true,
+	"by":        true,
+	"asc":       true,
+	"desc":      true,
+	"limit":     true,
+	"offset":    true,
+	"matches":   true,
+	"not":       true,
+	"is":        true,
+	"null":      true,
+	"true":      true,
+	"false":     true,
+	"in":        true,
+	"if":        true,
+	"then":      true,
+	"else":      true,
+	"case":      true,
+	"when":      true,
+	"end":       true,
+	"and":       true,
+	"or":        true,
+}
+
+func IsKeyword(s string) bool {
+	return keywords[s]
+}
+
 type Parser struct {
 	tokens []*Token
 	pos    int
@@ -29,6 +116,277 @@ type Parser struct {
 	la     *Token
 }
 
+func (p *Parser) module() *Node {
+	var decls []*Node
+	for {
+		if decl := p.predicate(); decl != nil {
+			decls = append(decls, decl)
+			continue
+		} else if decl = p.class(); decl != nil {
+			decls = append(decls, decl)
+			continue
+		}
+		break
+	}
+	if query := p.query(); query != nil {
+		return NewModuleNode(decls, query)
+	}
+	return nil
+}
+
+// predicate parses predicate name(Table var, ...) { cond }.
+func (p *Parser) predicate() *Node {
+	pos := p.pos
+	if kw := p.expect("predicate"); kw != nil {
+		if name := p.expectIdent(); name != nil && p.expect("(") != nil {
+			var params []*Node
+			if p.expect(")") == nil {
+				params = p.varDecls()
+				if params == nil || p.expect(")") == nil {
+					p.reset(pos)
+					return nil
+				}
+			}
+			if p.expect("{") != nil {
+				if body := p.expr(); body != nil && p.expect("}") != nil {
+					return NewPredicateNode(kw, name, params, body)
+				}
+			}
+		}
+	}
+	p.reset(pos)
+	return nil
+}
+
+// class parses class Name extends Base { Name() { cond } member() { expr } ... }.
+func (p *Parser) class() *Node {
+	pos := p.pos
+	if kw := p.expect("class"); kw != nil {
+		if name := p.expectIdent(); name != nil && p.expect("extends") != nil {
+			if base := p.expectIdent(); base != nil && p.expect("{") != nil {
+				var members []*Node
+				for {
+					if member := p.member(); member != nil {
+						members = append(members, member)
+						continue
+					}
+					break
+				}
+				if p.expect("}") != nil {
+					return NewClassNode(kw, name, base, members)
+				}
+			}
+		}
+	}
+	p.reset(pos)
+	return nil
+}
+
+func (p *Parser) member() *Node {
+	pos := p.pos
+	if name := p.expectIdent(); name != nil && p.expect("(") != nil && p.expect(")") != nil && p.expect("{") != nil {
+		if body := p.expr(); body != nil && p.expect("}") != nil {
+			return NewMemberNode(name, body)
+		}
+	}
+	p.reset(pos)
+	return nil
+}
+
+func (p *Parser) query() *Node {
+	pos := p.pos
+	var clauses []*Node
+	if kw := p.expect("from"); kw != nil {
+		// from Table var, ... where ... select expr, ...
+		decls := p.varDecls()
+		if decls == nil {
+			p.reset(pos)
+			return nil
+		}
+		clauses = append(clauses, NewClauseNode(NodeTypeFrom, kw, decls, nil))
+		where, ok := p.whereClause()
+		if !ok {
+			p.reset(pos)
+			return nil
+		}
+		if where != nil {
+			clauses = append(clauses, where)
+		}
+		if kw = p.expect("select"); kw == nil {
+			p.reset(pos)
+			return nil
+		}
+		items := p.exprs()
+		if items == nil {
+			p.reset(pos)
+			return nil
+		}
+		clauses = append(clauses, NewClauseNode(NodeTypeSelect, kw, items, nil))
+		return p.withTailClauses(pos, clauses)
+	}
+	kw := p.expect("select")
+	if kw == nil {
+		return nil
+	}
+	if decls := p.varDecls(); decls != nil {
+		// select Table var where ... select expr, ...
+		clauses = append(clauses, NewClauseNode(NodeTypeFrom, kw, decls, nil))
+		where, ok := p.whereClause()
+		if !ok {
+			p.reset(pos)
+			return nil
+		}
+		if where != nil {
+			clauses = append(clauses, where)
+		}
+		if kw = p.expect("select"); kw != nil {
+			items := p.exprs()
+			if items == nil {
+				p.reset(pos)
+				return nil
+			}
+			clauses = append(clauses, NewClauseNode(NodeTypeSelect, kw, items, nil))
+		}
+	} else if items := p.exprs(); items != nil {
+		// select expr, ... from Table var where ...
+		clauses = append(clauses, NewClauseNode(NodeTypeSelect, kw, items, nil))
+		if kw = p.expect("from"); kw == nil {
+			p.reset(pos)
+			return nil
+		}
+		if decls = p.varDecls(); decls == nil {
+			p.reset(pos)
+			return nil
+		}
+		clauses = append(clauses, NewClauseNode(NodeTypeFrom, kw, decls, nil))
+		where, ok := p.whereClause()
+		if !ok {
+			p.reset(pos)
+			return nil
+		}
+		if where != nil {
+			clauses = append(clauses, where)
+		}
+	} else {
+		p.reset(pos)
+		return nil
+	}
+	return p.withTailClauses(pos, clauses)
+}
+
+// withTailClauses completes a query started at pos with its tail clauses.
+func (p *Parser) withTailClauses(pos int, clauses []*Node) *Node {
+	tail, ok := p.tailClauses()
+	if !ok {
+		p.reset(pos)
+		return nil
+	}
+	return NewQueryNode(append(clauses, tail...))
+}
+
+// tailClauses parses the optional group by, order by, limit and offset clauses.
+func (p *Parser) tailClauses() ([]*Node, bool) {
+	pos := p.pos
+	var clauses []*Node
+	if kw := p.expect("group"); kw != nil {
+		var items []*Node
+		if p.expect("by") != nil {
+			items = p.exprs()
+		}
+		if items == nil {
+			p.reset(pos)
+			return nil, false
+		}
+		clauses = append(clauses, NewClauseNode(NodeTypeGroupBy, kw, items, nil))
+	}
+	if kw := p.expect("order"); kw != nil {
+		if p.expect("by") == nil {
+			p.reset(pos)
+			return nil, false
+		}
+		var items []*Node
+		for {
+			expr := p.expr()
+			if expr == nil {
+				p.reset(pos)
+				return nil, false
+			}
+			items = append(items, NewOrderItemNode(expr, p.expectOp("asc", "desc")))
+			if p.expect(",") == nil {
+				break
+			}
+		}
+		clauses = append(clauses, NewClauseNode(NodeTypeOrderBy, kw, items, nil))
+	}
+	for _, type_ := range []string{NodeTypeLimit, NodeTypeOffset} {
+		if kw := p.expect(type_); kw != nil {
+			expr := p.expr()
+			if expr == nil {
+				p.reset(pos)
+				return nil, false
+			}
+			clauses = append(clauses, NewClauseNode(type_, kw, nil, expr))
+		}
+	}
+	return clauses, true
+}
+
+// whereClause returns a nil node without error if there is no where keyword.
+func (p *Parser) whereClause() (*Node, bool) {
+	pos := p.pos
+	if kw := p.expect("where"); kw != nil {
+		if cond := p.expr(); cond != nil {
+			return NewClauseNode(NodeTypeWhere, kw, nil, cond), true
+		}
+		p.reset(pos)
+		return nil, false
+	}
+	return nil, true
+}
+
+func (p *Parser) exprs() []*Node {
+	pos := p.pos
+	var items []*Node
+	for {
+		item := p.expr()
+		if item == nil {
+			p.reset(pos)
+			return nil
+		}
+		items = append(items, item)
+		if p.expect(",") == nil {
+			return items
+		}
+	}
+}
+
+func (p *Parser) varDecls() []*Node {
+	pos := p.pos
+	var decls []*Node
+	for {
+		decl := p.varDecl()
+		if decl == nil {
+			p.reset(pos)
+			return nil
+		}
+		decls = append(decls, decl)
+		if p.expect(",") == nil {
+			return decls
+		}
+	}
+}
+
+func (p *Parser) varDecl() *Node {
+	pos := p.pos
+	if table := p.expectIdent(); table != nil {
+		if name := p.expectIdent(); name != nil {
+			return NewVarDeclNode(table, name)
+		}
+	}
+	p.reset(pos)
+	return nil
+}
+
 func (p *Parser) expr() *Node {
 	return p.logicalOrBinary()
 }
@@ -89,22 +447,93 @@ func (p *Parser) expectOp(ops ...string) *Token {
 }
 
 func (p *Parser) unary() *Node {
-	if op := p.expectOp("-", "!"); op != nil {
-		if x := p.compareBinary(); x != nil {
+	pos := p.pos
+	if op := p.expectOp("!", "not"); op != nil {
+		if x := p.unary(); x != nil {
 			return NewUnaryNode(op, x)
 		}
+		p.reset(pos)
+		return nil
 	}
 	return p.compareBinary()
 }
 
 func (p *Parser) compareBinary() *Node {
+	return p.binary(p.in, "==", "!=", "=~", "matches", ">=", ">", "<=", "<")
+}
+
+func (p *Parser) in() *Node {
+	x := p.isNull()
+	if x == nil {
+		return nil
+	}
+	pos := p.pos
+	not := p.expect("not")
+	if in := p.expect("in"); in != nil {
+		if set := p.set(); set != nil {
+			return NewInNode(x, in, not, set)
+		}
+	}
+	p.reset(pos)
+	return x
+}
+
+// set parses the right side of in: a parenthesized query or list of expressions.
+func (p *Parser) set() *Node {
+	pos := p.pos
+	if p.expect("(") == nil {
+		return nil
+	}
+	if q := p.query(); q != nil {
+		if p.expect(")") != nil {
+			return q
+		}
+		p.reset(pos)
+		return nil
+	}
+	if p.expect(")") != nil {
+		return NewListNode(nil)
+	}
+	if items := p.exprs(); items != nil && p.expect(")") != nil {
+		return NewListNode(items)
+	}
+	p.reset(pos)
+	return nil
+}
+
+func (p *Parser) isNull() *Node {
+	x := p.additiveBinary()
+	if x == nil {
+		return nil
+	}
+	pos := p.pos
+	if is := p.expect("is"); is != nil {
+		not := p.expect("not")
+		if p.expect("null") != nil {
+			return NewIsNullNode(x, is, not)
+		}
+		p.reset(pos)
+	}
+	return x
+}
+
+func (p *Parser) additiveBinary() *Node {
+	return p.binary(p.multiplicativeBinary, "+", "-")
+}
+
+func (p *Parser) multiplicativeBinary() *Node {
+	return p.binary(p.negation, "*", "/", "%")
+}
+
+// binary parses a left-associative chain of operands joined by ops.
+func (p *Parser) binary(operand func() *Node, ops ...string) *Node {
 	pos := p.pos
 	var lhs *Node
-	if lhs = p.primary(); lhs != nil {
+	if lhs = operand(); lhs != nil {
 		for {
 			tmp := p.pos
-			if op := p.expectOp("==", "!=", ">=", ">", "<=", "<"); op != nil {
-				if rhs := p.primary(); rhs != nil {
+			if op := p.expectOp(ops...); op != nil {
+				if rhs := operand(); rhs != nil {
 					lhs = NewBinaryNode(op, lhs, rhs)
 					continue
 				}
@@ -120,37 +549,51 @@ func (p *Parser) compareBinary() *Node {
 	return nil
 }
 
+func (p *Parser) negation() *Node {
+	pos := p.pos
+	if op := p.expectOp("-"); op != nil {
+		if x := p.negation(); x != nil {
+			// a minus sign directly followed by a number is a negative literal
+			if num := x.token; x.Type() == NodeTypeNumber && num.Start == op.End && !strings.HasPrefix(num.Text, "-") {
+				tok := NewToken(TokenTypeNumber, "-"+num.Text, op.Start, num.End)
+				tok.Line, tok.Column = op.Line, op.Column
+				return NewNumberNode(tok)
+			}
+			return NewUnaryNode(op, x)
+		}
+		p.reset(pos)
+		return nil
+	}
+	return p.primary()
+}
+
 func (p *Parser) primary() *Node {
 	pos := p.pos
 	var lhs *Node
 	if lhs = p.atom(); lhs != nil {
 		for {
 			tmp := p.pos
-			if p.expect("(") != nil {
-				var args []*Node
-				for {
-					if arg := p.expr(); arg != nil {
-						args = append(args, arg)
-					} else {
-						break
-					}
-					if p.expect(",") != nil {
+			if args, ok := p.callArgs(); ok {
+				lhs = NewCallNode(lhs, args)
+				continue
+			}
+			p.reset(tmp)
+			if lhs.Type() == NodeTypeIdent {
+				// parent+(a, b) must be written without spaces around the closure operator
+				if op := p.expectOp("+", "*"); op != nil && op.Start == lhs.token.End && p.la.Start == op.End {
+					if args, ok := p.callArgs(); ok {
+						lhs = NewClosureNode(lhs, op, args)
 						continue
-					} else {
-						break
 					}
 				}
-				if p.expect(")") != nil {
-					lhs = NewCallNode(lhs, args)
-					continue
-				}
+				p.reset(tmp)
 			}
-			p.reset(tmp)
 			if p.expect(".") != nil {
 				if x := p.expect(TokenTypeIdent); x != nil {
 					lhs = NewSelectorNode(lhs, x)
 					continue
 				}
+				p.reset(tmp)
 			}
 			break
 		}
@@ -168,14 +611,213 @@ func (p *Parser) primary() *Node {
 	return nil
 }
 
+func (p *Parser) callArgs() ([]*Node, bool) {
+	pos := p.pos
+	if p.expect("(") != nil {
+		var args []*Node
+		for {
+			if arg := p.expr(); arg != nil {
+				args = append(args, arg)
+			} else {
+				break
+			}
+			if p.expect(",") != nil {
+				continue
+			} else {
+				break
+			}
+		}
+		if p.expect(")") != nil {
+			return args, true
+		}
+	}
+	p.reset(pos)
+	return nil, false
+}
+
+// quantifier parses exists(decls [| cond]) and forall(decls [| guard] | cond).
+func (p *Parser) quantifier() *Node {
+	pos := p.pos
+	kw := p.expectOp("exists", "forall")
+	if kw == nil || p.expect("(") == nil {
+		p.reset(pos)
+		return nil
+	}
+	decls := p.varDecls()
+	if decls == nil {
+		p.reset(pos)
+		return nil
+	}
+	var conds []*Node
+	for p.expect("|") != nil {
+		cond := p.expr()
+		if cond == nil {
+			p.reset(pos)
+			return nil
+		}
+		conds = append(conds, cond)
+	}
+	if p.expect(")") == nil {
+		p.reset(pos)
+		return nil
+	}
+	if kw.Text == "exists" && len(conds) <= 1 {
+		var cond *Node
+		if len(conds) == 1 {
+			cond = conds[0]
+		}
+		return NewQuantifierNode(NodeTypeExists, kw, decls, nil, cond)
+	} else if kw.Text == "forall" && len(conds) == 1 {
+		return NewQuantifierNode(NodeTypeForall, kw, decls, nil, conds[0])
+	} else if kw.Text == "forall" && len(conds) == 2 {
+		return NewQuantifierNode(NodeTypeForall, kw, decls, conds[0], conds[1])
+	}
+	p.reset(pos)
+	return nil
+}
+
+// aggregate parses count(decls [| cond]) and agg(decls [| cond] | expr), where
+// concat also takes a separator: concat(decls [| cond] | expr, sep). Without
+// declarations, agg(expr) aggregates over a group of the enclosing query.
+func (p *Parser) aggregate() *Node {
+	pos := p.pos
+	name := p.expectOp("count", "sum", "min", "max", "avg", "concat")
+	if name == nil || p.expect("(") == nil {
+		p.reset(pos)
+		return nil
+	}
+	decls := p.varDecls()
+	if decls == nil {
+		// agg(expr [, sep]) aggregates over the rows of a group
+		if expr := p.expr(); expr != nil {
+			var sep *Node
+			ok := true
+			if name.Text == "concat" && p.expect(",") != nil {
+				sep = p.expr()
+				ok = sep != nil
+			}
+			if ok && p.expect(")") != nil {
+				return NewAggregateNode(name, nil, nil, expr, sep)
+			}
+		}
+		p.reset(pos)
+		return nil
+	}
+	var parts []*Node
+	for p.expect("|") != nil {
+		if p.la.Text == "|" {
+			// empty condition
+			parts = append(parts, nil)
+			continue
+		}
+		part := p.expr()
+		if part == nil {
+			p.reset(pos)
+			return nil
+		}
+		parts = append(parts, part)
+	}
+	var sep *Node
+	if name.Text == "concat" && p.expect(",") != nil {
+		if sep = p.expr(); sep == nil {
+			p.reset(pos)
+			return nil
+		}
+	}
+	if p.expect(")") == nil {
+		p.reset(pos)
+		return nil
+	}
+	if name.Text == "count" && len(parts) <= 1 {
+		var cond *Node
+		if len(parts) == 1 {
+			cond = parts[0]
+		}
+		return NewAggregateNode(name, decls, cond, nil, nil)
+	} else if name.Text != "count" && len(parts) == 1 && parts[0] != nil {
+		return NewAggregateNode(name, decls, nil, parts[0], sep)
+	} else if name.Text != "count" && len(parts) == 2 && parts[1] != nil {
+		return NewAggregateNode(name, decls, parts[0], parts[1], sep)
+	}
+	p.reset(pos)
+	return nil
+}
+
+// conditional parses if cond then x else y, or case when cond then x ... [else y] end.
+func (p *Parser) conditional() *Node {
+	pos := p.pos
+	if kw := p.expect("if"); kw != nil {
+		if cond := p.expr(); cond != nil && p.expect("then") != nil {
+			if then := p.expr(); then != nil && p.expect("else") != nil {
+				if else_ := p.expr(); else_ != nil {
+					return NewIfNode(kw, cond, then, else_)
+				}
+			}
+		}
+		p.reset(pos)
+		return nil
+	}
+	kw := p.expect("case")
+	if kw == nil {
+		return nil
+	}
+	var whens []*Node
+	for {
+		tmp := p.pos
+		when := p.expect("when")
+		if when == nil {
+			break
+		}
+		cond := p.expr()
+		if cond == nil || p.expect("then") == nil {
+			p.reset(tmp)
+			break
+		}
+		result := p.expr()
+		if result == nil {
+			p.reset(tmp)
+			break
+		}
+		whens = append(whens, NewWhenNode(when, cond, result))
+	}
+	if whens == nil {
+		p.reset(pos)
+		return nil
+	}
+	var else_ *Node
+	if p.expect("else") != nil {
+		if else_ = p.expr(); else_ == nil {
+			p.reset(pos)
+			return nil
+		}
+	}
+	if p.expect("end") == nil {
+		p.reset(pos)
+		return nil
+	}
+	return NewCaseNode(kw, whens, else_)
+}
+
 func (p *Parser) atom() *Node {
 	pos := p.pos
-	if tok := p.expect(TokenTypeIdent); tok != nil {
+	if n := p.quantifier(); n != nil {
+		return n
+	} else if n = p.aggregate(); n != nil {
+		return n
+	} else if n = p.conditional(); n != nil {
+		return n
+	} else if tok := p.expectIdent(); tok != nil {
 		return NewIdentNode(tok)
 	} else if tok = p.expect(TokenTypeNumber); tok != nil {
 		return NewNumberNode(tok)
 	} else if tok = p.expect(TokenTypeString); tok != nil {
 		return NewStringNode(tok)
+	} else if tok = p.expect(TokenTypeParam); tok != nil {
+		return NewParamNode(tok)
+	} else if tok = p.expect("null"); tok != nil {
+		return NewNullNode(tok)
+	} else if tok = p.expectOp("true", "false"); tok != nil {
+		return NewBoolNode(tok)
 	} else if p.expect("(") != nil {
 		n := p.expr()
 		if n == nil {
@@ -208,6 +850,15 @@ func (p *Parser) forward() {
 	p.read()
 }
 
+func (p *Parser) expectIdent() *Token {
+	if p.la.Type == TokenTypeIdent && !IsKeyword(p.la.Text) {
+		ret := p.la
+		p.forward()
+		return ret
+	}
+	return nil
+}
+
 func (p *Parser) expect(t string) *Token {
 	if p.la.Type == t || p.la.Text == t {
 		ret := p.la
@@ -2,11 +2,15 @@ package parser
 
 const TokenTypeEndOfFile = "end_of_file"
 const TokenTypeWhitespace = "whitespace"
+const TokenTypeComment = "comment"
+const TokenTypeInvalid = "invalid"
 const TokenTypeIdent = "ident"
 const TokenTypeNumber = "number"
 const TokenTypeString = "string"
+const TokenTypeParam = "param"
 const TokenTypeOpDot = "."
 const TokenTypeOpEqualEqual = "=="
+const TokenTypeOpMatch = "=~"
 const TokenTypeOpNotEqual = "!="
 const TokenTypeOpGreaterEqual = ">="
 const TokenTypeOpLessEqual = "<="
@@ -14,14 +18,26 @@ const TokenTypeOpGreater = ">"
 const TokenTypeOpLess = "<"
 const TokenTypeOpLeftParen = "("
 const TokenTypeOpRightParen = ")"
+const TokenTypeOpComma = ","
+const TokenTypeOpPipe = "|"
+const TokenTypeOpLeftBrace = "{"
+const TokenTypeOpRightBrace = "}"
+const TokenTypeOpPlus = "+"
+const TokenTypeOpStar = "*"
+const TokenTypeOpMinus = "-"
+const TokenTypeOpSlash = "/"
+const TokenTypeOpPercent = "%"
+const TokenTypeOpNot = "!"
 
 func NewToken(type_, text string, start, end int) *Token {
-	return &Token{type_, text, start, end}
+	return &Token{Type: type_, Text: text, Start: start, End: end}
 }
 
 type Token struct {
-	Type  string
-	Text  string
-	Start int
-	End   int
+	Type   string
+	Text   string
+	Start  int // byte offset
+	End    int
+	Line   int // 1-based line of Start
+	Column int // 1-based column of Start, in runes
 }
@@ -1,22 +1,38 @@
 package parser
 
 import (
-	"errors"
 	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 func Tokenize(text string) ([]*Token, error) {
+	tokens, _, err := tokenize(text)
+	return tokens, err
+}
+
+// tokenize returns the tokens of text and, separately, its comments.
+func tokenize(text string) ([]*Token, []*Token, error) {
 	if text == "" {
-		return nil, errors.New("empty text")
+		tok := NewToken(TokenTypeEndOfFile, "EOF", 0, 0)
+		tok.Line, tok.Column = 1, 1
+		return nil, nil, &Error{Token: tok, Msg: "empty text"}
 	}
-	tokenizer := &Tokenizer{text: text, la: text[0]}
-	return tokenizer.Parse()
+	tokenizer := &Tokenizer{text: text, la: text[0], line: 1}
+	tokens, err := tokenizer.Parse()
+	return tokens, tokenizer.comments, err
 }
 
 type Tokenizer struct {
-	text string
-	la   byte
-	pos  int
+	text      string
+	la        byte
+	pos       int
+	line      int
+	lineStart int // offset of the first byte of line
+	tokLine   int // line and column of the token being scanned
+	tokColumn int
+	comments  []*Token
 }
 
 func (t *Tokenizer) Parse() ([]*Token, error) {
@@ -26,7 +42,10 @@ func (t *Tokenizer) Parse() ([]*Token, error) {
 		if err != nil {
 			return nil, err
 		}
-		if tok.Type == TokenTypeWhitespace {
+		if tok.Type == TokenTypeComment {
+			t.comments = append(t.comments, tok)
+			continue
+		} else if tok.Type == TokenTypeWhitespace {
 			continue
 		}
 		ret = append(ret, tok)
@@ -38,24 +57,57 @@ func (t *Tokenizer) Parse() ([]*Token, error) {
 }
 
 func (t *Tokenizer) next() (*Token, error) {
+	t.tokLine, t.tokColumn = t.line, t.column()
 	if t.la == 0 {
-		return NewToken(TokenTypeEndOfFile, "EOF", t.pos, t.pos), nil
-	} else if tok := t.op(); tok != nil {
+		return t.newToken(TokenTypeEndOfFile, t.pos), nil
+	} else if tok, err := t.comment(); err != nil || tok != nil {
+		return tok, err
+	} else if tok = t.op(); tok != nil {
 		return tok, nil
 	} else if tok = t.whitespace(); tok != nil {
 		return tok, nil
 	} else if tok = t.ident(); tok != nil {
 		return tok, nil
-	} else if tok = t.number(); tok != nil {
-		return tok, nil
-	} else if tok = t.string(); tok != nil {
+	} else if tok = t.param(); tok != nil {
 		return tok, nil
+	} else if tok, err = t.number(); err != nil || tok != nil {
+		return tok, err
+	} else if tok, err = t.string(); err != nil || tok != nil {
+		return tok, err
+	}
+	r, size := t.rune()
+	return nil, t.errorf(t.pos, t.pos+size, "unexpected character %q", r)
+}
+
+// errorf returns an error at the text from start to end, where start is the
+// start of the token being scanned.
+func (t *Tokenizer) errorf(start, end int, format string, args ...any) *Error {
+	tok := NewToken(TokenTypeInvalid, t.text[start:end], start, end)
+	tok.Line, tok.Column = t.tokLine, t.tokColumn
+	return &Error{Token: tok, Msg: fmt.Sprintf(format, args...)}
+}
+
+// column returns the 1-based column of the current position, in runes.
+func (t *Tokenizer) column() int {
+	return utf8.RuneCountInString(t.text[t.lineStart:t.pos]) + 1
+}
+
+// rune returns the rune at the current position and its size in bytes, or 0 at
+// the end of text.
+func (t *Tokenizer) rune() (rune, int) {
+	return utf8.DecodeRuneInString(t.text[t.pos:])
+}
+
+// advance moves past the rune at the current position.
+func (t *Tokenizer) advance() {
+	_, size := t.rune()
+	for i := 0; i < size; i++ {
+		t.forward()
 	}
-	return nil, fmt.Errorf("fail to tokenize '%s' at %d: \"%s\"", string(t.la), t.pos, t.text)
 }
 
-func (t *Tokenizer) isLetter(b byte) bool {
-	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+func (t *Tokenizer) isLetter(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
 }
 
 func (t *Tokenizer) isDigit(b byte) bool {
@@ -63,62 +115,131 @@ func (t *Tokenizer) isDigit(b byte) bool {
 }
 
 func (t *Tokenizer) ident() *Token {
-	if t.isLetter(t.la) {
+	if r, _ := t.rune(); t.isLetter(r) {
 		start := t.pos
-		t.forward()
-		for t.isLetter(t.la) || t.isDigit(t.la) {
-			t.forward()
+		t.advance()
+		for r, _ = t.rune(); t.isLetter(r) || unicode.IsDigit(r); r, _ = t.rune() {
+			t.advance()
 		}
 		return t.newToken(TokenTypeIdent, start)
 	}
 	return nil
 }
 
-func (t *Tokenizer) number() *Token {
-	if t.isDigit(t.la) {
-		start := t.pos
+// param scans a bind parameter, $ followed by a name.
+func (t *Tokenizer) param() *Token {
+	if t.la != '$' {
+		return nil
+	}
+	start := t.pos
+	t.forward()
+	if t.ident() == nil {
+		t.pos = start
+		t.read()
+		return nil
+	}
+	return t.newToken(TokenTypeParam, start)
+}
+
+// number scans a decimal number with optional fraction and exponent, or an
+// integer with a 0x, 0o or 0b prefix, and checks it is well formed.
+func (t *Tokenizer) number() (*Token, error) {
+	if !t.isDigit(t.la) {
+		return nil, nil
+	}
+	start := t.pos
+	t.forward()
+	if hasBasePrefix(t.text[start:]) {
 		t.forward()
-		for t.isDigit(t.la) {
+	} else {
+		for t.isDigit(t.la) || t.la == '_' {
 			t.forward()
 		}
 		if t.la == '.' {
 			t.forward()
-			for t.isDigit(t.la) {
+			for t.isDigit(t.la) || t.la == '_' {
+				t.forward()
+			}
+		}
+		if t.la == 'e' || t.la == 'E' {
+			t.forward()
+			if t.la == '+' || t.la == '-' {
 				t.forward()
 			}
 		}
-		return t.newToken(TokenTypeNumber, start)
 	}
-	return nil
+	// letters run into the number so that 12ab is an error rather than 12 ab
+	for r, _ := t.rune(); t.isLetter(r) || unicode.IsDigit(r); r, _ = t.rune() {
+		t.advance()
+	}
+	tok := t.newToken(TokenTypeNumber, start)
+	var err error
+	if IsFloat(tok.Text) {
+		_, err = ParseFloat(tok.Text)
+	} else {
+		// the parser checks the range once a minus sign is merged in, so that
+		// only the magnitude of the least int must fit here
+		_, err = ParseInt("-" + tok.Text)
+	}
+	if err != nil {
+		return nil, &Error{Token: tok, Msg: fmt.Sprintf("invalid number %s", tok.Text)}
+	}
+	return tok, nil
 }
 
-func (t *Tokenizer) string() *Token {
-	if t.la == '\'' {
-		start := t.pos
+// string scans a '...', "..." or `...` string and checks its escapes.
+func (t *Tokenizer) string() (*Token, error) {
+	quote := t.la
+	if quote != '\'' && quote != '"' && quote != '`' {
+		return nil, nil
+	}
+	start := t.pos
+	t.forward()
+	for t.la != quote {
+		if t.pos >= len(t.text) {
+			return nil, t.errorf(start, len(t.text), "unterminated string")
+		}
+		if t.la == '\\' && quote != '`' {
+			t.forward()
+		}
 		t.forward()
-		for {
-			if t.la == '\\' {
-				t.forward()
-				t.forward()
-			} else {
-				t.forward()
-				if t.la == '\'' || t.la == 0 {
-					break
-				}
-			}
+	}
+	t.forward()
+	tok := t.newToken(TokenTypeString, start)
+	if _, err := Unquote(tok.Text); err != nil {
+		return nil, &Error{Token: tok, Msg: err.Error()}
+	}
+	return tok, nil
+}
+
+// comment scans a // line comment or a /* */ block comment.
+func (t *Tokenizer) comment() (*Token, error) {
+	start := t.pos
+	if strings.HasPrefix(t.text[t.pos:], "//") {
+		for t.la != '\n' && t.la != 0 {
+			t.forward()
 		}
-		if t.la == '\'' {
+		return t.newToken(TokenTypeComment, start), nil
+	} else if strings.HasPrefix(t.text[t.pos:], "/*") {
+		end := strings.Index(t.text[t.pos+2:], "*/")
+		if end < 0 {
+			return nil, t.errorf(start, len(t.text), "unterminated comment")
+		}
+		for t.pos < start+2+end+2 {
 			t.forward()
 		}
-		return t.newToken(TokenTypeString, start)
+		return t.newToken(TokenTypeComment, start), nil
 	}
-	return nil
+	return nil, nil
 }
 
 func (t *Tokenizer) whitespace() *Token {
 	start := t.pos
-	for t.la == ' ' {
-		t.forward()
+	for {
+		if r, _ := t.rune(); !unicode.IsSpace(r) {
+			break
+		}
+		t.advance()
 	}
 	if start != t.pos {
 		return t.newToken(TokenTypeWhitespace, start)
@@ -135,6 +256,9 @@ func (t *Tokenizer) op() *Token {
 		if t.la == '=' {
 			t.forward()
 			type_ = TokenTypeOpEqualEqual
+		} else if t.la == '~' {
+			t.forward()
+			type_ = TokenTypeOpMatch
 		}
 	case '<':
 		t.forward()
@@ -157,6 +281,8 @@ func (t *Tokenizer) op() *Token {
 		if t.la == '=' {
 			t.forward()
 			type_ = TokenTypeOpNotEqual
+		} else {
+			type_ = TokenTypeOpNot
 		}
 	case '(':
 		t.forward()
@@ -167,6 +293,33 @@ func (t *Tokenizer) op() *Token {
 	case '.':
 		t.forward()
 		type_ = TokenTypeOpDot
+	case ',':
+		t.forward()
+		type_ = TokenTypeOpComma
+	case '|':
+		t.forward()
+		type_ = TokenTypeOpPipe
+	case '{':
+		t.forward()
+		type_ = TokenTypeOpLeftBrace
+	case '}':
+		t.forward()
+		type_ = TokenTypeOpRightBrace
+	case '+':
+		t.forward()
+		type_ = TokenTypeOpPlus
+	case '*':
+		t.forward()
+		type_ = TokenTypeOpStar
+	case '-':
+		t.forward()
+		type_ = TokenTypeOpMinus
+	case '/':
+		t.forward()
+		type_ = TokenTypeOpSlash
+	case '%':
+		t.forward()
+		type_ = TokenTypeOpPercent
 	}
 	if type_ != "" {
 		return t.newToken(type_, start)
@@ -175,11 +328,21 @@ func (t *Tokenizer) op() *Token {
 }
 
 func (t *Tokenizer) newToken(type_ string, start int) *Token {
-	return NewToken(type_, t.text[start:t.pos], start, t.pos)
+	text := t.text[start:t.pos]
+	if type_ == TokenTypeEndOfFile {
+		text = "EOF"
+	}
+	tok := NewToken(type_, text, start, t.pos)
+	tok.Line, tok.Column = t.tokLine, t.tokColumn
+	return tok
 }
 
 func (t *Tokenizer) forward() {
 	if t.pos < len(t.text) {
+		if t.la == '\n' {
+			t.line++
+			t.lineStart = t.pos + 1
+		}
 		t.pos++
 		t.read()
 	}
@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// positions returns the tokens as text@line:column.
+func positions(tokens []*Token) []string {
+	result := make([]string, len(tokens))
+	for i, tok := range tokens {
+		result[i] = fmt.Sprintf("%s@%d:%d", tok.Text, tok.Line, tok.Column)
+	}
+	return result
+}
+
+func TestTokenizePositions(t *testing.T) {
+	tests := []struct {
+		src      string
+		tokens   []string
+		comments []string
+	}{
+		{
+			"a.b == 1",
+			[]string{"a@1:1", ".@1:2", "b@1:3", "==@1:5", "1@1:8", "EOF@1:9"},
+			nil,
+		},
+		{
+			"a\n\tb\r\n  c",
+			[]string{"a@1:1", "b@2:2", "c@3:3", "EOF@3:4"},
+			nil,
+		},
+		{
+			// columns count runes, not bytes
+			"'héllo' == naïve\nñ",
+			[]string{"'héllo'@1:1", "==@1:9", "naïve@1:12", "ñ@2:1", "EOF@2:2"},
+			nil,
+		},
+		{
+			"a\u00a0b\u3000c\u2003d",
+			[]string{"a@1:1", "b@1:3", "c@1:5", "d@1:7", "EOF@1:8"},
+			nil,
+		},
+		{
+			"a // one\nb /* two\nthree */ c/**/d",
+			[]string{"a@1:1", "b@2:1", "c@3:10", "d@3:15", "EOF@3:16"},
+			[]string{"// one@1:3", "/* two\nthree */@2:3", "/**/@3:11"},
+		},
+		{
+			"a // last",
+			[]string{"a@1:1", "EOF@1:10"},
+			[]string{"// last@1:3"},
+		},
+		{
+			"a/b",
+			[]string{"a@1:1", "/@1:2", "b@1:3", "EOF@1:4"},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		tokens, comments, err := tokenize(test.src)
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		for _, tok := range tokens {
+			if tok.Type == TokenTypeEndOfFile {
+				tok.Text = "EOF"
+			}
+		}
+		if got := positions(tokens); !reflect.DeepEqual(got, test.tokens) {
+			t.Errorf("%q: tokens %v, want %v", test.src, got, test.tokens)
+		}
+		if got := positions(comments); len(got) > 0 || test.comments != nil {
+			if !reflect.DeepEqual(got, test.comments) {
+				t.Errorf("%q: comments %v, want %v", test.src, got, test.comments)
+			}
+		}
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"a /* open", "unterminated comment at line 1, column 3"},
+		{"a\n  /* open\n*", "unterminated comment at line 2, column 3"},
+		{"a\n 'open", "unterminated string at line 2, column 2"},
+		{"é #", "unexpected character '#' at line 1, column 3"},
+		{"a == 12ab", "invalid number 12ab at line 1, column 6"},
+		{"1__0", "invalid number 1__0 at line 1, column 1"},
+		{"1_ + 1", "invalid number 1_ at line 1, column 1"},
+		{"0x", "invalid number 0x at line 1, column 1"},
+		{"0b12", "invalid number 0b12 at line 1, column 1"},
+		{"1e", "invalid number 1e at line 1, column 1"},
+		{"99999999999999999999", "invalid number 99999999999999999999 at line 1, column 1"},
+		{"a\n 'x\\q'", "invalid escape in string 'x\\q' at line 2, column 2"},
+		{"`a", "unterminated string at line 1, column 1"},
+		{"$ a", "unexpected character '$' at line 1, column 1"},
+		{"", "empty text at line 1, column 1"},
+	}
+	for _, test := range tests {
+		_, err := Tokenize(test.src)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%q: got error %v, want %s", test.src, err, test.want)
+		}
+	}
+}
+
+func TestTokenizeLiterals(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"0x1F 0o17 0b101 1_000 010", []string{"number 0x1F", "number 0o17", "number 0b101", "number 1_000", "number 010"}},
+		{"1.5 1. 1e3 2.5E-3 1e+3", []string{"number 1.5", "number 1.", "number 1e3", "number 2.5E-3", "number 1e+3"}},
+		// the magnitude of the least int is a number, whose range the parser
+		// checks once it knows the sign
+		{"-9223372036854775808", []string{"- -", "number 9223372036854775808"}},
+		{"a-1", []string{"ident a", "- -", "number 1"}},
+		{`'a\'b' "c\"d" ` + "`e\\f`", []string{`string 'a\'b'`, `string "c\"d"`, "string `e\\f`"}},
+		{"'' \"\" ``", []string{"string ''", `string ""`, "string ``"}},
+		{"naïve _x1 日本語 x٣ $ñ", []string{"ident naïve", "ident _x1", "ident 日本語", "ident x٣", "param $ñ"}},
+	}
+	for _, test := range tests {
+		tokens, err := Tokenize(test.src)
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+			continue
+		}
+		got := make([]string, len(tokens)-1)
+		for i, tok := range tokens[:len(tokens)-1] {
+			got[i] = tok.Type + " " + tok.Text
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %q, want %q", test.src, got, test.want)
+		}
+	}
+}
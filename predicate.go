@@ -0,0 +1,501 @@
+package ql
+
+import (
+	"github.com/lincaiyong/ql/parser"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// predicateCalls returns the names each predicate calls, predicates or not.
+func predicateCalls(predicates map[string]*parser.Node) map[string][]string {
+	calls := make(map[string][]string, len(predicates))
+	for name, decl := range predicates {
+		decl.PredicateBody().Visit(func(node *parser.Node) {
+			if node.Type() == parser.NodeTypeCall && node.Callee().Type() == parser.NodeTypeIdent {
+				calls[name] = append(calls[name], node.Callee().Ident())
+			}
+		})
+	}
+	return calls
+}
+
+// reachable returns the names name calls, directly or through other predicates.
+func reachable(calls map[string][]string, name string) map[string]bool {
+	visited := make(map[string]bool)
+	stack := append([]string(nil), calls[name]...)
+	for len(stack) > 0 {
+		callee := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !visited[callee] {
+			visited[callee] = true
+			stack = append(stack, calls[callee]...)
+		}
+	}
+	return visited
+}
+
+// recursivePredicates returns the predicates that can reach themselves through calls.
+func recursivePredicates(predicates map[string]*parser.Node) map[string]bool {
+	calls := predicateCalls(predicates)
+	result := make(map[string]bool)
+	for name := range predicates {
+		if reachable(calls, name)[name] {
+			result[name] = true
+		}
+	}
+	return result
+}
+
+// component returns the predicates that are mutually recursive with name, name
+// included, in order of name.
+func (v *Evaluator[T]) component(name string) []string {
+	calls := predicateCalls(v.predicates)
+	result := []string{name}
+	for callee := range reachable(calls, name) {
+		if callee != name && v.predicates[callee] != nil && reachable(calls, callee)[name] {
+			result = append(result, callee)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// call keeps the bindings whose arguments satisfy a user-defined predicate.
+// Plain predicates are evaluated once for every distinct tuple of arguments,
+// recursive predicates and closures are materialized first.
+func (v *Evaluator[T]) call(node *parser.Node, all []*Binding[T]) []*Binding[T] {
+	if node.Callee().Type() != parser.NodeTypeIdent {
+		panic(newEvalError(node.Callee(), "%s is not a predicate", node.Callee().Type()))
+	}
+	name := node.Callee().Ident()
+	decl := v.predicates[name]
+	if decl == nil {
+		panic(newEvalError(node.Callee(), "predicate %s not found", name))
+	}
+	if len(node.Args()) != len(decl.PredicateParams()) {
+		panic(newEvalError(node, "predicate %s expects %d arguments, got %d", name, len(decl.PredicateParams()), len(node.Args())))
+	}
+	if node.Closure() != "" && len(decl.PredicateParams()) != 2 {
+		panic(newEvalError(node, "closure of predicate %s must be binary", name))
+	}
+	slots := make([]int, len(node.Args()))
+	for i, arg := range node.Args() {
+		if arg.Type() != parser.NodeTypeIdent || v.lookup(arg.Ident()) < 0 {
+			panic(newEvalError(arg, "argument %d of predicate %s must be a variable", i, name))
+		}
+		slots[i] = v.lookup(arg.Ident())
+	}
+	sub := v.sub()
+	sub.declare(decl.PredicateParams())
+	keys := make(map[*Binding[T]]string, len(all))
+	args := make(map[string]*Binding[T])
+	var inner []*Binding[T]
+next:
+	for _, b := range all {
+		rs := make([]*Record[T], len(slots))
+		for i, slot := range slots {
+			// a record only satisfies a parameter if it belongs to the parameter's
+			// table, and the missing record of an empty group satisfies none
+			if b.records[slot] == nil {
+				continue next
+			} else if rs[i] = sub.vars[i].table.recordMap[b.records[slot].id]; rs[i] == nil {
+				continue next
+			}
+		}
+		key := recordsKey(rs)
+		keys[b] = key
+		if _, ok := args[key]; !ok {
+			args[key] = &Binding[T]{records: rs}
+			inner = append(inner, args[key])
+		}
+	}
+	sat := make(map[string]struct{})
+	if node.Closure() != "" {
+		for key := range v.closure(decl) {
+			sat[key] = struct{}{}
+		}
+		if node.Closure() == "*" {
+			for key, b := range args {
+				if b.records[0].id == b.records[1].id {
+					sat[key] = struct{}{}
+				}
+			}
+		}
+	} else if v.recursive[name] {
+		for key := range v.relation(decl) {
+			sat[key] = struct{}{}
+		}
+	} else {
+		for _, b := range sub.EvalSet(decl.PredicateBody(), inner) {
+			sat[recordsKey(b.records)] = struct{}{}
+		}
+	}
+	result := make([]*Binding[T], 0, len(all))
+	for _, b := range all {
+		if key, ok := keys[b]; ok {
+			if _, ok = sat[key]; ok {
+				result = append(result, b)
+			}
+		}
+	}
+	return result
+}
+
+// relation returns every tuple satisfying a predicate. The predicates of a
+// recursive component whose bodies only call each other positively, outside of
+// negations, universal quantifiers, conditionals and aggregates, are computed
+// together by semi-naive evaluation. Others are computed as the least fixpoint
+// of their body, where recursive calls see the tuples found by the previous
+// iteration.
+func (v *Evaluator[T]) relation(decl *parser.Node) map[string][]*Record[T] {
+	name := decl.PredicateName()
+	if r, ok := v.relations[name]; ok {
+		return r
+	}
+	if component, rules, ok := v.componentRules(name); ok {
+		for member, r := range v.fixpoint(component, rules) {
+			v.relations[member] = r
+		}
+		return v.relations[name]
+	}
+	sub := v.sub()
+	sub.declare(decl.PredicateParams())
+	all := sub.product()
+	current := make(map[string][]*Record[T])
+	v.relations[name] = current
+	v.pending[name] = true
+	for {
+		next := make(map[string][]*Record[T], len(current))
+		for _, b := range sub.EvalSet(decl.PredicateBody(), all) {
+			next[recordsKey(b.records)] = b.records
+		}
+		for key := range current {
+			if _, ok := next[key]; !ok {
+				panic(newEvalError(decl, "predicate %s is not monotonic", name))
+			}
+		}
+		if len(next) == len(current) {
+			break
+		}
+		current = next
+		v.relations[name] = current
+		if !v.recursive[name] {
+			break
+		}
+	}
+	delete(v.pending, name)
+	if len(v.pending) > 0 {
+		// the result depends on the approximation of a predicate that is still pending
+		delete(v.relations, name)
+	}
+	return current
+}
+
+// componentRules returns the predicates mutually recursive with name and their
+// bodies rewritten as rules, or false if a body cannot be rewritten.
+func (v *Evaluator[T]) componentRules(name string) ([]string, map[string][]rule, bool) {
+	component := v.component(name)
+	members := make(map[string]bool, len(component))
+	for _, member := range component {
+		members[member] = true
+	}
+	rules := make(map[string][]rule, len(component))
+	for _, member := range component {
+		decl := v.predicates[member]
+		var scope []string
+		for _, param := range decl.PredicateParams() {
+			scope = append(scope, param.VarDeclName())
+		}
+		var ok bool
+		if rules[member], ok = bodyRules(decl.PredicateBody(), members, scope); !ok {
+			return nil, nil, false
+		}
+	}
+	return component, rules, true
+}
+
+// rule is one way for a predicate to hold: the variables its body declares
+// with exists, besides the parameters, and a conjunction of conditions on them.
+type rule struct {
+	decls []*parser.Node
+	conds []*parser.Node
+}
+
+// bodyRules rewrites node, part of the body of a predicate, as a union of rules,
+// given the names of the variables in scope. It reports false if node calls a
+// predicate of component other than as a condition of its own, with variables
+// for arguments, or if an exists declares a variable already in scope.
+func bodyRules(node *parser.Node, component map[string]bool, scope []string) ([]rule, bool) {
+	switch node.Type() {
+	case parser.NodeTypeParen:
+		return bodyRules(node.ParenTarget(), component, scope)
+	case parser.NodeTypeBinary:
+		if node.Op() != "or" && node.Op() != "and" {
+			break
+		}
+		lhs, ok := bodyRules(node.BinaryLhs(), component, scope)
+		if !ok {
+			return nil, false
+		}
+		rhs, ok := bodyRules(node.BinaryRhs(), component, scope)
+		if !ok {
+			return nil, false
+		} else if node.Op() == "or" {
+			return append(lhs, rhs...), true
+		}
+		return conjoin(lhs, rhs)
+	case parser.NodeTypeExists:
+		result := []rule{{decls: node.QuantifierDecls()}}
+		scope = slices.Clip(scope)
+		for _, decl := range node.QuantifierDecls() {
+			if slices.Contains(scope, decl.VarDeclName()) {
+				return nil, false
+			}
+			scope = append(scope, decl.VarDeclName())
+		}
+		for _, part := range []*parser.Node{node.QuantifierGuard(), node.QuantifierCond()} {
+			if part == nil {
+				continue
+			}
+			rules, ok := bodyRules(part, component, scope)
+			if !ok {
+				return nil, false
+			}
+			if result, ok = conjoin(result, rules); !ok {
+				return nil, false
+			}
+		}
+		return result, true
+	case parser.NodeTypeCall:
+		if callee := node.Callee(); callee.Type() == parser.NodeTypeIdent && component[callee.Ident()] && node.Closure() == "" {
+			for _, arg := range node.Args() {
+				if arg.Type() != parser.NodeTypeIdent || !slices.Contains(scope, arg.Ident()) {
+					return nil, false
+				}
+			}
+			return []rule{{conds: []*parser.Node{node}}}, true
+		}
+	}
+	calls := false
+	node.Visit(func(n *parser.Node) {
+		if n.Type() == parser.NodeTypeCall && n.Callee().Type() == parser.NodeTypeIdent && component[n.Callee().Ident()] {
+			calls = true
+		}
+	})
+	return []rule{{conds: []*parser.Node{node}}}, !calls
+}
+
+// conjoin returns the rules holding when a rule of lhs and a rule of rhs both
+// hold. It reports false if the two declare variables of the same name.
+func conjoin(lhs, rhs []rule) ([]rule, bool) {
+	result := make([]rule, 0, len(lhs)*len(rhs))
+	for _, l := range lhs {
+		for _, r := range rhs {
+			for _, decl := range r.decls {
+				if slices.ContainsFunc(l.decls, func(d *parser.Node) bool { return d.VarDeclName() == decl.VarDeclName() }) {
+					return nil, false
+				}
+			}
+			result = append(result, rule{
+				decls: append(slices.Clip(l.decls), r.decls...),
+				conds: append(slices.Clip(l.conds), r.conds...),
+			})
+		}
+	}
+	return result, true
+}
+
+// atom is a call within a rule to a predicate of the component being computed,
+// with the slots of its arguments.
+type atom struct {
+	name  string
+	slots []int
+}
+
+// fixpoint computes the predicates of a recursive component from their rules
+// by semi-naive evaluation. Each round only derives the tuples using one found
+// by the previous round, looking up the tuples of the other calls by the
+// arguments already bound, until a round finds nothing new.
+func (v *Evaluator[T]) fixpoint(component []string, rules map[string][]rule) map[string]map[string][]*Record[T] {
+	type compiled struct {
+		name  string
+		sub   *Evaluator[T]
+		atoms []atom
+		conds []*parser.Node // the other conditions
+	}
+	var compiledRules []compiled
+	for _, name := range component {
+		decl := v.predicates[name]
+		for _, r := range rules[name] {
+			c := compiled{name: name, sub: v.sub()}
+			c.sub.declare(decl.PredicateParams())
+			c.sub.declare(r.decls)
+			for _, cond := range r.conds {
+				if cond.Type() != parser.NodeTypeCall || !slices.Contains(component, cond.Callee().Ident()) {
+					c.conds = append(c.conds, cond)
+					continue
+				}
+				callee := cond.Callee().Ident()
+				if n := len(v.predicates[callee].PredicateParams()); len(cond.Args()) != n {
+					panic(newEvalError(cond, "predicate %s expects %d arguments, got %d", callee, n, len(cond.Args())))
+				}
+				a := atom{name: callee}
+				for _, arg := range cond.Args() {
+					a.slots = append(a.slots, c.sub.lookup(arg.Ident()))
+				}
+				c.atoms = append(c.atoms, a)
+			}
+			compiledRules = append(compiledRules, c)
+		}
+	}
+
+	relations := make(map[string]map[string][]*Record[T], len(component))
+	delta := make(map[string]map[string][]*Record[T], len(component))
+	for _, name := range component {
+		relations[name] = make(map[string][]*Record[T])
+	}
+	var next map[string]map[string][]*Record[T]
+	// derive adds the tuples of the parameters of the bindings of c satisfying
+	// its other conditions to next, unless they were found before
+	derive := func(c compiled, all []*Binding[T], bound []bool) {
+		all, _ = c.sub.bind(all, nil, bound, c.conds)
+		n := len(v.predicates[c.name].PredicateParams())
+		for _, b := range all {
+			key := recordsKey(b.records[:n])
+			if _, ok := relations[c.name][key]; ok {
+				continue
+			}
+			if next[c.name] == nil {
+				next[c.name] = make(map[string][]*Record[T])
+			}
+			next[c.name][key] = b.records[:n:n]
+		}
+	}
+	for round := 0; round == 0 || len(delta) > 0; round++ {
+		next = make(map[string]map[string][]*Record[T])
+		for _, c := range compiledRules {
+			width := len(c.sub.vars)
+			if round == 0 {
+				if len(c.atoms) == 0 {
+					derive(c, []*Binding[T]{{records: make([]*Record[T], width)}}, make([]bool, width))
+				}
+				continue
+			}
+			for j, a := range c.atoms {
+				if len(delta[a.name]) == 0 {
+					continue
+				}
+				bound := make([]bool, width)
+				all := c.sub.expand([]*Binding[T]{{records: make([]*Record[T], width)}}, bound, a.slots, delta[a.name])
+				for k, other := range c.atoms {
+					if k != j {
+						all = c.sub.expand(all, bound, other.slots, relations[other.name])
+					}
+				}
+				derive(c, all, bound)
+			}
+		}
+		for name, tuples := range next {
+			maps.Copy(relations[name], tuples)
+		}
+		delta = next
+	}
+	return relations
+}
+
+// expand extends every binding of all with each tuple of relation whose records
+// are those of the variables of slots already bound, binding the others, and
+// marks them bound.
+func (v *Evaluator[T]) expand(all []*Binding[T], bound []bool, slots []int, relation map[string][]*Record[T]) []*Binding[T] {
+	var keyed []int
+	for i, slot := range slots {
+		if bound[slot] {
+			keyed = append(keyed, i)
+		}
+	}
+	index := make(map[string][][]*Record[T])
+	for _, tuple := range relation {
+		key := keyOf(tuple, keyed, func(i int) int { return i })
+		index[key] = append(index[key], tuple)
+	}
+	var result []*Binding[T]
+	for _, b := range all {
+	next:
+		for _, tuple := range index[keyOf(b.records, keyed, func(i int) int { return slots[i] })] {
+			rs := slices.Clone(b.records)
+			for i, slot := range slots {
+				if bound[slot] {
+					continue
+				}
+				// a record only binds a variable if it belongs to the variable's table
+				r := v.vars[slot].table.recordMap[tuple[i].id]
+				if r == nil || rs[slot] != nil && rs[slot] != r {
+					continue next
+				}
+				rs[slot] = r
+			}
+			result = append(result, &Binding[T]{records: rs})
+		}
+	}
+	for _, slot := range slots {
+		bound[slot] = true
+	}
+	return result
+}
+
+// keyOf returns the key of the records at the positions slot(i) for i in
+// positions.
+func keyOf[T any](records []*Record[T], positions []int, slot func(i int) int) string {
+	var sb strings.Builder
+	for _, i := range positions {
+		sb.WriteString(strconv.Itoa(records[slot(i)].id))
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
+
+// closure returns the transitive closure of a binary predicate.
+func (v *Evaluator[T]) closure(decl *parser.Node) map[string][]*Record[T] {
+	name := decl.PredicateName() + "+"
+	if r, ok := v.relations[name]; ok {
+		return r
+	}
+	relation := v.relation(decl)
+	edges := make(map[int][]*Record[T])
+	for _, rs := range relation {
+		edges[rs[0].id] = append(edges[rs[0].id], rs[1])
+	}
+	result := make(map[string][]*Record[T])
+	for _, rs := range relation {
+		from := rs[0]
+		visited := make(map[int]bool)
+		stack := []*Record[T]{rs[1]}
+		for len(stack) > 0 {
+			to := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if visited[to.id] {
+				continue
+			}
+			visited[to.id] = true
+			result[recordsKey([]*Record[T]{from, to})] = []*Record[T]{from, to}
+			stack = append(stack, edges[to.id]...)
+		}
+	}
+	if len(v.pending) == 0 {
+		v.relations[name] = result
+	}
+	return result
+}
+
+func recordsKey[T any](records []*Record[T]) string {
+	var sb strings.Builder
+	for i, r := range records {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.Itoa(r.id))
+	}
+	return sb.String()
+}
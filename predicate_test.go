@@ -0,0 +1,59 @@
+package ql
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPredicate(t *testing.T) {
+	db := newTestDatabase(10)
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{
+			"predicate big(Entity a) { a.num > 7 } select Entity a where big(a) select a.num",
+			[][]string{{"8"}, {"9"}},
+		},
+		{
+			"predicate anc(Entity a, Entity b) { a.par == b or exists(Entity c | anc(a, c) and anc(c, b)) } select Entity a, Entity b where anc(a, b) and a.num == 9 select b.num",
+			[][]string{{"0"}, {"1"}, {"4"}},
+		},
+		{
+			"predicate anc(Entity a, Entity b) { a.par == b or exists(Entity c | a.par == c and anc(c, b)) } select Entity a, Entity b where anc(a, b) and b.num == 1 select a.num",
+			[][]string{{"3"}, {"4"}, {"7"}, {"8"}, {"9"}},
+		},
+		{
+			// mutually recursive predicates are computed together
+			"predicate even(Entity a) { a.num == 0 or exists(Entity b | a.par == b and odd(b)) } predicate odd(Entity a) { exists(Entity b | a.par == b and even(b)) } select Entity a where odd(a) select a.num",
+			[][]string{{"1"}, {"2"}, {"7"}, {"8"}, {"9"}},
+		},
+		{
+			// the variables of the two exists share a name, so the body is
+			// evaluated as a whole
+			"predicate anc(Entity a, Entity b) { a.par == b or exists(Entity c | a.par == c and anc(c, b)) and exists(Entity c | c.num == 0) } select Entity a, Entity b where anc(a, b) and a.num == 9 select b.num",
+			[][]string{{"0"}, {"1"}, {"4"}},
+		},
+		{
+			"predicate parent(Entity a, Entity b) { a.par == b } select Entity a, Entity b where parent+(a, b) and a.num == 9 select b.num",
+			[][]string{{"0"}, {"1"}, {"4"}},
+		},
+	}
+	for _, test := range tests {
+		if got := selectRows(t, db, test.query); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestPredicateNotMonotonic(t *testing.T) {
+	db := newTestDatabase(10)
+	q := "predicate p(Entity a) { a.num == 0 or exists(Entity b | b.par == a and not p(b)) } select Entity a where p(a)"
+	_, err := db.Select(q)
+	var e *EvalError
+	if !errors.As(err, &e) || !strings.Contains(e.Error(), "predicate p is not monotonic") {
+		t.Errorf("%s: got error %v, want p not monotonic", q, err)
+	}
+}
@@ -18,6 +18,11 @@ type Record[T any] struct {
 	data  []int
 }
 
+// Entity returns the entity of the record. The nil record, left unbound in the
+// only group of an aggregate over no rows, has none.
 func (r *Record[T]) Entity() *T {
+	if r == nil {
+		return nil
+	}
 	return r.table.db.entities[r.id]
 }
@@ -0,0 +1,33 @@
+package ql
+
+func NewResultSet(columns []string) *ResultSet {
+	return &ResultSet{
+		columns: columns,
+		rows:    make([][]*Value, 0),
+	}
+}
+
+type ResultSet struct {
+	columns []string
+	rows    [][]*Value
+}
+
+func (rs *ResultSet) AddRow(row []*Value) {
+	rs.rows = append(rs.rows, row)
+}
+
+func (rs *ResultSet) Columns() []string {
+	return rs.columns
+}
+
+func (rs *ResultSet) Rows() [][]*Value {
+	return rs.rows
+}
+
+func (rs *ResultSet) Len() int {
+	return len(rs.rows)
+}
+
+func (rs *ResultSet) Row(i int) []*Value {
+	return rs.rows[i]
+}
@@ -1,6 +1,6 @@
 package ql
 
-func NewTable[T any](name string, db *Database[T], fields []string, getters map[string]func(*T) *Value) *Table[T] {
+func NewTable[T any](name string, db *Database[T], fields []string, getters map[string]func(*T) *Value, types map[string]ValueType) *Table[T] {
 	fieldMap := make(map[string]int, len(fields))
 	for i, field := range fields {
 		fieldMap[field] = i
@@ -8,6 +8,9 @@ func NewTable[T any](name string, db *Database[T], fields []string, getters map[
 	if getters == nil {
 		getters = make(map[string]func(*T) *Value)
 	}
+	if types == nil {
+		types = make(map[string]ValueType)
+	}
 	return &Table[T]{
 		name:      name,
 		db:        db,
@@ -16,6 +19,7 @@ func NewTable[T any](name string, db *Database[T], fields []string, getters map[
 		records:   make([]*Record[T], 0),
 		recordMap: make(map[int]*Record[T]),
 		getters:   getters,
+		types:     types,
 	}
 }
 
@@ -27,6 +31,7 @@ type Table[T any] struct {
 	records   []*Record[T]
 	recordMap map[int]*Record[T]
 	getters   map[string]func(*T) *Value
+	types     map[string]ValueType // result types of the getters
 }
 
 func (t *Table[T]) AddRecord(r *Record[T]) {
@@ -42,6 +47,13 @@ func (t *Table[T]) Getter(n string) func(*T) *Value {
 	return t.getters[n]
 }
 
-func (t *Table[T]) Define(n string, getter func(*T) *Value) {
+// GetterType returns the type of the values of getter n other than null.
+func (t *Table[T]) GetterType(n string) ValueType {
+	return t.types[n]
+}
+
+// Define adds getter n, which returns null or values of type type_.
+func (t *Table[T]) Define(n string, type_ ValueType, getter func(*T) *Value) {
 	t.getters[n] = getter
+	t.types[n] = type_
 }
@@ -1,13 +1,31 @@
 package ql
 
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
 type ValueType string
 
 const (
 	ValueTypeBool   = ValueType("bool")
 	ValueTypeInt    = ValueType("int")
+	ValueTypeFloat  = ValueType("float")
 	ValueTypeString = ValueType("string")
+	ValueTypeEntity = ValueType("entity")
+	ValueTypeNull   = ValueType("null")
 )
 
+// NewNullValue returns the null value. Getters may also return nil, which is
+// treated as null.
+func NewNullValue() *Value {
+	return &Value{
+		type_: ValueTypeNull,
+	}
+}
+
 func NewBoolValue(b bool) *Value {
 	return &Value{
 		type_:     ValueTypeBool,
@@ -22,6 +40,13 @@ func NewIntValue(i int) *Value {
 	}
 }
 
+func NewFloatValue(f float64) *Value {
+	return &Value{
+		type_:      ValueTypeFloat,
+		floatValue: f,
+	}
+}
+
 func NewStringValue(s string) *Value {
 	return &Value{
 		type_:       ValueTypeString,
@@ -29,17 +54,38 @@ func NewStringValue(s string) *Value {
 	}
 }
 
+// NewEntityValue returns a value referring to an entity of a database, so that
+// getters can navigate to related entities. A nil entity is null.
+func NewEntityValue[T any](e *T) *Value {
+	if e == nil {
+		return nil
+	}
+	return &Value{
+		type_:       ValueTypeEntity,
+		entityValue: e,
+	}
+}
+
 type Value struct {
 	type_       ValueType
 	boolValue   bool
 	intValue    int
+	floatValue  float64
 	stringValue string
+	entityValue any
 }
 
 func (v *Value) Type() ValueType {
+	if v == nil {
+		return ValueTypeNull
+	}
 	return v.type_
 }
 
+func (v *Value) IsNull() bool {
+	return v == nil || v.type_ == ValueTypeNull
+}
+
 func (v *Value) BoolValue() bool {
 	return v.boolValue
 }
@@ -48,6 +94,79 @@ func (v *Value) IntValue() int {
 	return v.intValue
 }
 
+func (v *Value) FloatValue() float64 {
+	return v.floatValue
+}
+
+// IsNumber reports whether v is an int or a float.
+func (v *Value) IsNumber() bool {
+	return v.type_ == ValueTypeInt || v.type_ == ValueTypeFloat
+}
+
+// NumberValue returns an int or float value as float.
+func (v *Value) NumberValue() float64 {
+	if v.type_ == ValueTypeInt {
+		return float64(v.intValue)
+	}
+	return v.floatValue
+}
+
 func (v *Value) StringValue() string {
 	return v.stringValue
 }
+
+func (v *Value) EntityValue() any {
+	return v.entityValue
+}
+
+func (v *Value) String() string {
+	if v.IsNull() {
+		return "null"
+	}
+	switch v.type_ {
+	case ValueTypeBool:
+		return strconv.FormatBool(v.boolValue)
+	case ValueTypeInt:
+		return strconv.Itoa(v.intValue)
+	case ValueTypeFloat:
+		return formatFloat(v.floatValue)
+	case ValueTypeEntity:
+		return fmt.Sprint(v.entityValue)
+	default:
+		return v.stringValue
+	}
+}
+
+// Key returns a form of v usable as a map key. Two values have the same key iff
+// they are equal, with an int equal to a float of the same number; all nulls
+// have the same key.
+func (v *Value) Key() string {
+	if v.IsNull() {
+		return "n"
+	}
+	switch v.type_ {
+	case ValueTypeBool:
+		return "b" + strconv.FormatBool(v.boolValue)
+	case ValueTypeInt:
+		return "i" + strconv.Itoa(v.intValue)
+	case ValueTypeFloat:
+		if f := v.floatValue; f == math.Trunc(f) && math.Abs(f) < 1<<62 {
+			return "i" + strconv.Itoa(int(f))
+		}
+		return "f" + strconv.FormatFloat(v.floatValue, 'g', -1, 64)
+	case ValueTypeEntity:
+		return fmt.Sprintf("e%p", v.entityValue)
+	default:
+		return "s" + strconv.Quote(v.stringValue)
+	}
+}
+
+// formatFloat formats f in the shortest form that reads back as f, keeping a
+// decimal point so that floats can be told apart from ints.
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}